@@ -2,51 +2,176 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/zackiles/task-graph-fs/internal/fsparse"
+	"github.com/zackiles/task-graph-fs/internal/orchestration"
+	"github.com/zackiles/task-graph-fs/internal/planfile"
+	"github.com/zackiles/task-graph-fs/internal/printutils"
+	"github.com/zackiles/task-graph-fs/internal/report"
 	"github.com/zackiles/task-graph-fs/internal/services"
+	"github.com/zackiles/task-graph-fs/internal/state"
 )
 
 // NewApplyCmd creates and returns the "apply" command.
 func NewApplyCmd(parser *fsparse.Parser) *cobra.Command {
 	var opts struct {
-		autoApprove bool
-		workflowDir string
+		autoApprove     bool
+		workflowDir     string
+		state           string
+		retryBackoff    time.Duration
+		retryMaxBackoff time.Duration
+		retryJitter     float64
+		lockTimeout     time.Duration
+		timeout         time.Duration
+		maxRunning      int
+		output          string
+		parse           parseFlags
 	}
 
 	applyCmd := &cobra.Command{
-		Use:   "apply",
+		Use:   "apply [planfile]",
 		Short: "Apply the planned changes to workflows and tasks",
 		Long: `The "apply" command executes the planned changes to the workflows and tasks.
 It ensures that only approved changes are applied and supports interactive or
-automatic approval modes.`,
-		Args: cobra.NoArgs, // No positional arguments are expected
+automatic approval modes.
+
+Given a plan file saved by "tgfs plan", it executes exactly that plan without
+asking again, and refuses if the workspace or state changed since the plan was
+made.
+
+The state file is locked while the apply runs, so that two applies can't
+overwrite each other's state. An apply that finds the state locked waits up to
+--lock-timeout and then fails with the lock's ID; "tgfs force-unlock <id>"
+releases a lock whose process is gone.
+
+With --output json, progress is streamed as newline-delimited JSON events,
+described in the README, instead of text. It can't ask for approval, so it
+needs --auto-approve or a plan file.`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
-			return runApply(ctx, parser, opts.workflowDir, opts.autoApprove)
+			if err := report.CheckFormat(opts.output); err != nil {
+				return err
+			}
+			settings := applySettings{
+				retryPolicy: orchestration.RetryPolicy{
+					InitialBackoff: opts.retryBackoff,
+					MaxBackoff:     opts.retryMaxBackoff,
+					Multiplier:     orchestration.DefaultRetryPolicy().Multiplier,
+					Jitter:         opts.retryJitter,
+				},
+				lockTimeout: opts.lockTimeout,
+				timeout:     opts.timeout,
+				maxRunning:  opts.maxRunning,
+			}
+			planPath := ""
+			if len(args) == 1 {
+				planPath = args[0]
+				if opts.state != "" {
+					return fmt.Errorf("--state can't be used with a plan file, the plan records the state file it was made against")
+				}
+			}
+			if opts.output == report.FormatJSON {
+				if planPath == "" && !opts.autoApprove {
+					return fmt.Errorf("--output json can't ask for approval, use --auto-approve or apply a saved plan")
+				}
+				return runApplyJSON(ctx, cmd.OutOrStdout(), parser, opts.workflowDir, opts.state, planPath, settings, opts.parse)
+			}
+			return runApply(ctx, parser, opts.workflowDir, opts.state, planPath, opts.autoApprove, settings, opts.parse)
 		},
 	}
 
+	defaultRetry := orchestration.DefaultRetryPolicy()
 	applyCmd.Flags().BoolVar(&opts.autoApprove, "auto-approve", false, "Skip interactive approval")
 	applyCmd.Flags().StringVarP(&opts.workflowDir, "dir", "d", ".", "Directory containing workflows")
+	applyCmd.Flags().StringVar(&opts.state, "state", "", "State file to apply against (default: tgfs-state.json in the workspace root)")
+	applyCmd.Flags().DurationVar(&opts.retryBackoff, "retry-backoff", defaultRetry.InitialBackoff, "Delay before the first retry of a failed task, doubled on each further retry")
+	applyCmd.Flags().DurationVar(&opts.retryMaxBackoff, "retry-max-backoff", defaultRetry.MaxBackoff, "Maximum delay between task retries")
+	applyCmd.Flags().Float64Var(&opts.retryJitter, "retry-jitter", defaultRetry.Jitter, "Random fraction (0-1) applied to each retry delay")
+	applyCmd.Flags().DurationVar(&opts.lockTimeout, "lock-timeout", 0, "How long to wait for another apply to release the state lock")
+	applyCmd.Flags().DurationVar(&opts.timeout, "timeout", 0, "Maximum time the apply may run, e.g. 12h (default: no limit)")
+	applyCmd.Flags().IntVar(&opts.maxRunning, "max-running", 0, "Maximum number of tasks to run at once across all workflows (default: max_running in tgfs.yaml, otherwise no limit)")
+	applyCmd.Flags().StringVarP(&opts.output, "output", "o", report.FormatText, "Output format: text or json")
+	opts.parse.register(applyCmd.Flags())
 
 	return applyCmd
 }
 
+// applySettings are the apply flags that control how the apply runs
+type applySettings struct {
+	retryPolicy orchestration.RetryPolicy
+	lockTimeout time.Duration
+	timeout     time.Duration
+	maxRunning  int
+}
+
+// options returns the options to apply the workspace at workflowDir with,
+// combining the flags with the workspace's tgfs.yaml
+func (s applySettings) options(workflowDir string) (services.ApplyOptions, error) {
+	opts := services.ApplyOptions{
+		WorkflowDir: workflowDir,
+		RetryPolicy: s.retryPolicy,
+		Timeout:     s.timeout,
+		MaxRunning:  s.maxRunning,
+	}
+	if err := configureApply(&opts, workflowDir); err != nil {
+		return services.ApplyOptions{}, err
+	}
+	return opts, nil
+}
+
+// lockState takes the state lock for the rest of an apply
+func lockState(ctx context.Context, stateFile string, timeout time.Duration) (*state.Lock, error) {
+	lock, err := state.AcquireLock(ctx, stateFile, "apply", timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock the state: %w", err)
+	}
+	return lock, nil
+}
+
 // runApply contains the core logic for the "apply" command.
-func runApply(ctx context.Context, parser *fsparse.Parser, workflowDir string, autoApprove bool) error {
+func runApply(ctx context.Context, parser *fsparse.Parser, workflowDir, stateFile, planPath string, autoApprove bool, settings applySettings, parse parseFlags) error {
+	if planPath != "" {
+		return runApplyPlan(ctx, parser, planPath, settings)
+	}
+
+	stateFile, err := statePath(workflowDir, stateFile)
+	if err != nil {
+		return err
+	}
+
+	// The lock is held from planning until the state is saved, so that the
+	// confirmed plan is still accurate when it runs
+	lock, err := lockState(ctx, stateFile, settings.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	parser, err = workspaceParser(parser, workflowDir, parse)
+	if err != nil {
+		return err
+	}
+
+	opts, err := settings.options(workflowDir)
+	if err != nil {
+		return err
+	}
+	opts.StatePath = stateFile
+	opts.AutoApprove = autoApprove
+
 	applyService := services.NewApplyService(parser)
 
 	// Check for changes first
-	result, err := applyService.Plan(ctx, services.ApplyOptions{
-		WorkflowDir: workflowDir,
-		AutoApprove: autoApprove,
-	})
+	result, err := applyService.Plan(ctx, opts)
 	if err != nil {
 		return fmt.Errorf("error during planning: %w", err)
 	}
@@ -70,11 +195,12 @@ func runApply(ctx context.Context, parser *fsparse.Parser, workflowDir string, a
 
 	handleInterrupts(cancel)
 
-	// Execute apply
-	if err := applyService.Apply(ctx, services.ApplyOptions{
-		WorkflowDir: workflowDir,
-		AutoApprove: autoApprove,
-	}); err != nil {
+	// Execute exactly what was planned and confirmed
+	finalState, err := applyService.ApplyWorkflows(ctx, opts, result.Workflows)
+	if finalState != nil {
+		printutils.PrintApplyResults(finalState)
+	}
+	if err != nil {
 		return fmt.Errorf("error during apply: %w", err)
 	}
 
@@ -82,6 +208,154 @@ func runApply(ctx context.Context, parser *fsparse.Parser, workflowDir string, a
 	return nil
 }
 
+// runApplyPlan executes a saved plan. The plan was reviewed when it was made,
+// so no confirmation is asked for.
+func runApplyPlan(ctx context.Context, parser *fsparse.Parser, planPath string, settings applySettings) error {
+	plan, err := planfile.Read(planPath)
+	if err != nil {
+		return err
+	}
+
+	if !plan.HasChanges {
+		fmt.Println("No changes to apply")
+		return nil
+	}
+
+	opts, err := settings.options(plan.WorkflowDir)
+	if err != nil {
+		return err
+	}
+	opts.AutoApprove = true
+
+	if err := plan.CheckWorkspace(); err != nil {
+		return err
+	}
+	lock, err := lockState(ctx, plan.StateFile(), settings.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	// Set up cancellation context
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	handleInterrupts(cancel)
+
+	applyService := services.NewApplyService(parser)
+	finalState, err := applyService.ApplyPlan(ctx, opts, plan)
+	if finalState != nil {
+		printutils.PrintApplyResults(finalState)
+	}
+	if err != nil {
+		return fmt.Errorf("error during apply: %w", err)
+	}
+
+	fmt.Println("\nApply complete!")
+	return nil
+}
+
+// runApplyJSON applies the workspace, or a saved plan, without asking for
+// approval and streams its progress to w as newline-delimited JSON events. The
+// stream always ends with an apply_finished event, including when the apply
+// fails before any task runs.
+func runApplyJSON(ctx context.Context, w io.Writer, parser *fsparse.Parser, workflowDir, stateFile, planPath string, settings applySettings, parse parseFlags) error {
+	events := report.NewEventWriter(w)
+
+	err := applyJSON(ctx, events, parser, workflowDir, stateFile, planPath, settings, parse)
+	switch {
+	case errors.Is(err, errNoChanges):
+		events.Finished(report.StatusUnchanged, nil)
+		return nil
+	case errors.Is(err, context.Canceled):
+		events.Finished(report.StatusCancelled, err)
+	case err != nil:
+		events.Finished(report.StatusFailed, err)
+	default:
+		events.Finished(report.StatusCompleted, nil)
+	}
+	return err
+}
+
+// errNoChanges stops an apply that has nothing to do
+var errNoChanges = errors.New("no changes to apply")
+
+func applyJSON(ctx context.Context, events *report.EventWriter, parser *fsparse.Parser, workflowDir, stateFile, planPath string, settings applySettings, parse parseFlags) error {
+	// Set up cancellation context
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	handleInterrupts(cancel)
+
+	if planPath != "" {
+		plan, err := planfile.Read(planPath)
+		if err != nil {
+			return err
+		}
+		events.Plan(report.NewPlan(plan.Diff, plan.Warnings, planPath))
+		if !plan.HasChanges {
+			return errNoChanges
+		}
+		opts, err := settings.options(plan.WorkflowDir)
+		if err != nil {
+			return err
+		}
+		opts.AutoApprove = true
+		opts.OnEvent = events.Orchestration
+
+		if err := plan.CheckWorkspace(); err != nil {
+			return err
+		}
+		lock, err := lockState(ctx, plan.StateFile(), settings.lockTimeout)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+
+		applyService := services.NewApplyService(parser)
+		if _, err := applyService.ApplyPlan(ctx, opts, plan); err != nil {
+			return fmt.Errorf("error during apply: %w", err)
+		}
+		return nil
+	}
+
+	opts, err := settings.options(workflowDir)
+	if err != nil {
+		return err
+	}
+	opts.AutoApprove = true
+	opts.OnEvent = events.Orchestration
+	opts.StatePath, err = statePath(workflowDir, stateFile)
+	if err != nil {
+		return err
+	}
+	lock, err := lockState(ctx, opts.StatePath, settings.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	parser, err = workspaceParser(parser, workflowDir, parse)
+	if err != nil {
+		return err
+	}
+
+	applyService := services.NewApplyService(parser)
+	result, err := applyService.Plan(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("error during planning: %w", err)
+	}
+	events.Plan(report.NewPlan(result.Diff, result.Warnings, ""))
+	if !result.HasChanges {
+		return errNoChanges
+	}
+
+	if _, err := applyService.ApplyWorkflows(ctx, opts, result.Workflows); err != nil {
+		return fmt.Errorf("error during apply: %w", err)
+	}
+	return nil
+}
+
 // confirmChanges prompts the user for confirmation to apply changes.
 func confirmChanges() bool {
 	fmt.Printf("\nDo you want to apply these changes? [y/N] ")
@@ -97,7 +371,8 @@ func handleInterrupts(cancelFunc context.CancelFunc) {
 
 	go func() {
 		<-sigChan
-		fmt.Println("\nReceived interrupt signal, gracefully shutting down...")
+		// Keep stdout clean for --output json
+		fmt.Fprintln(os.Stderr, "\nReceived interrupt signal, gracefully shutting down...")
 		cancelFunc()
 	}()
 }
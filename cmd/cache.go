@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/zackiles/task-graph-fs/internal/workspace"
+)
+
+// NewCacheCmd creates and returns the "cache" command.
+func NewCacheCmd() *cobra.Command {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the task extraction cache",
+		Long: `Task properties extracted by gopilot or another provider are cached under
+.tgfs/cache, keyed by the task file's content and the provider, model and
+prompt version used. The "cache" commands manage that cache.`,
+	}
+
+	cacheCmd.AddCommand(newCacheCleanCmd())
+	return cacheCmd
+}
+
+func newCacheCleanCmd() *cobra.Command {
+	var opts struct {
+		workflowDir string
+	}
+
+	cleanCmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Remove every cached task extraction",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCacheClean(cmd.OutOrStdout(), opts.workflowDir)
+		},
+	}
+
+	cleanCmd.Flags().StringVarP(&opts.workflowDir, "dir", "d", ".", "Directory containing workflows")
+	return cleanCmd
+}
+
+// runCacheClean contains the core logic for the "cache clean" command.
+func runCacheClean(out io.Writer, workflowDir string) error {
+	cacheDir := workspace.CacheDir(workflowDir)
+	if err := os.RemoveAll(cacheDir); err != nil {
+		return fmt.Errorf("failed to remove cache: %w", err)
+	}
+	fmt.Fprintf(out, "Removed extraction cache at %s\n", cacheDir)
+	return nil
+}
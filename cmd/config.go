@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/zackiles/task-graph-fs/internal/config"
+	"github.com/zackiles/task-graph-fs/internal/fsparse"
+	"github.com/zackiles/task-graph-fs/internal/gopilotcli"
+	"github.com/zackiles/task-graph-fs/internal/history"
+	"github.com/zackiles/task-graph-fs/internal/planfile"
+	"github.com/zackiles/task-graph-fs/internal/services"
+	"github.com/zackiles/task-graph-fs/internal/workspace"
+)
+
+// parseFlags are the flags shared by every command that parses the workspace
+type parseFlags struct {
+	noCache     bool
+	parallelism int
+}
+
+func (f *parseFlags) register(flags *pflag.FlagSet) {
+	flags.BoolVar(&f.noCache, "no-cache", false, "Extract every task again instead of using cached extractions")
+	flags.IntVar(&f.parallelism, "parallelism", fsparse.DefaultParallelism, "Maximum number of tasks to extract at once")
+}
+
+// statePath returns the state file of the workspace: the --state flag as given,
+// otherwise the path set in tgfs.yaml or tgfs-state.json, both relative to the
+// workspace root
+func statePath(workflowDir, flagPath string) (string, error) {
+	if flagPath != "" {
+		return flagPath, nil
+	}
+	cfg, err := config.Load(workflowDir)
+	if err != nil {
+		return "", err
+	}
+	return cfg.StatePath(workflowDir), nil
+}
+
+// configureApply sets the apply options that come from the workspace's
+// tgfs.yaml: the run history limits, the cap on running tasks unless
+// --max-running set one, and per-workflow settings
+func configureApply(opts *services.ApplyOptions, workflowDir string) error {
+	cfg, err := config.Load(workflowDir)
+	if err != nil {
+		return err
+	}
+
+	opts.Retention = history.Retention{
+		Keep:   cfg.History.Keep,
+		MaxAge: time.Duration(cfg.History.MaxAge),
+	}
+	if opts.MaxRunning == 0 {
+		opts.MaxRunning = cfg.MaxRunning
+	}
+	opts.Workflows = make(map[string]services.WorkflowOptions, len(cfg.Workflows))
+	for name, w := range cfg.Workflows {
+		opts.Workflows[name] = services.WorkflowOptions{
+			Timeout:    time.Duration(w.Timeout),
+			MaxRunning: w.MaxRunning,
+		}
+	}
+	return nil
+}
+
+// planOutPath returns where "tgfs plan" saves the plan: the --out flag as given,
+// otherwise .tgfs-plan in the workspace root
+func planOutPath(workflowDir, flagPath string) string {
+	if flagPath != "" {
+		return flagPath
+	}
+	return filepath.Join(workflowDir, planfile.DefaultFileName)
+}
+
+// workspaceParser returns the parser to use for the given workspace. When the
+// workspace's tgfs.yaml selects an extraction provider, that provider replaces
+// the default one. Extractions are cached under the workspace's .tgfs
+// directory unless --no-cache is set.
+func workspaceParser(parser *fsparse.Parser, workflowDir string, flags parseFlags) (*fsparse.Parser, error) {
+	cfg, err := config.Load(workflowDir)
+	if err != nil {
+		return nil, err
+	}
+
+	extractor := parser.Extractor()
+	switch cfg.Extractor.Provider {
+	case config.ProviderGopilot:
+		if path := cfg.Extractor.Gopilot.Path; path != "" {
+			extractor = gopilotcli.NewRealGopilotWithPath(path)
+		}
+	case config.ProviderOpenAI:
+		openAI := cfg.Extractor.OpenAI
+		extractor = gopilotcli.NewOpenAIGopilot(openAI.BaseURL, openAI.Model, openAI.APIKeyEnv, time.Duration(openAI.Timeout))
+	}
+
+	if !flags.noCache {
+		extractor = gopilotcli.NewCachingExtractor(extractor, workspace.CacheDir(workflowDir))
+	}
+
+	parser = fsparse.NewParserWithExtractor(extractor)
+	parser.SetParallelism(flags.parallelism)
+	return parser, nil
+}
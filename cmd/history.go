@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zackiles/task-graph-fs/internal/history"
+	"github.com/zackiles/task-graph-fs/internal/printutils"
+	"github.com/zackiles/task-graph-fs/internal/report"
+)
+
+// NewHistoryCmd creates and returns the "history" command.
+func NewHistoryCmd() *cobra.Command {
+	var opts struct {
+		workflowDir string
+		limit       int
+	}
+
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "List previous applies",
+		Long: `The "history" command lists the applies recorded under .tgfs/runs, most recent
+first. Use "tgfs show <run-id>" to inspect one of them. How many runs are kept
+is set by the history section of tgfs.yaml.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runHistory(cmd.OutOrStdout(), opts.workflowDir, opts.limit)
+		},
+	}
+
+	historyCmd.Flags().StringVarP(&opts.workflowDir, "dir", "d", ".", "Directory containing workflows")
+	historyCmd.Flags().IntVarP(&opts.limit, "limit", "n", 0, "Show at most this many runs (default: all)")
+	return historyCmd
+}
+
+// runHistory contains the core logic for the "history" command.
+func runHistory(out io.Writer, workflowDir string, limit int) error {
+	records, err := history.List(workflowDir)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(out, "No runs recorded yet")
+		return nil
+	}
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN ID\tSTARTED\tDURATION\tSTATUS\tTASKS")
+	for _, record := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			record.RunID,
+			record.StartedAt.Local().Format(time.DateTime),
+			record.Duration,
+			record.Status,
+			taskCounts(record))
+	}
+	return tw.Flush()
+}
+
+// taskCounts summarizes how many of a run's tasks completed
+func taskCounts(record *history.Record) string {
+	total, completed := 0, 0
+	for _, w := range record.Results {
+		for _, t := range w.Tasks {
+			total++
+			if t.Status == "completed" {
+				completed++
+			}
+		}
+	}
+	return fmt.Sprintf("%d/%d completed", completed, total)
+}
+
+// NewShowCmd creates and returns the "show" command.
+func NewShowCmd() *cobra.Command {
+	var opts struct {
+		workflowDir string
+		output      string
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show <run-id>",
+		Short: "Show the record of a previous apply",
+		Long: `The "show" command prints the record of an apply: the task specs and
+dependencies it ran, how each task ended and where its logs are. With
+--output json the record is printed as stored.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := report.CheckFormat(opts.output); err != nil {
+				return err
+			}
+			return runShow(cmd.OutOrStdout(), opts.workflowDir, args[0], opts.output)
+		},
+	}
+
+	showCmd.Flags().StringVarP(&opts.workflowDir, "dir", "d", ".", "Directory containing workflows")
+	showCmd.Flags().StringVarP(&opts.output, "output", "o", report.FormatText, "Output format: text or json")
+	return showCmd
+}
+
+// runShow contains the core logic for the "show" command.
+func runShow(out io.Writer, workflowDir, runID, output string) error {
+	record, err := history.Read(workflowDir, runID)
+	if err != nil {
+		return err
+	}
+
+	if output == report.FormatJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(record)
+	}
+
+	fmt.Fprintf(out, "Run %s\n", record.RunID)
+	fmt.Fprintf(out, "  Status:   %s\n", record.Status)
+	fmt.Fprintf(out, "  Started:  %s\n", record.StartedAt.Local().Format(time.DateTime))
+	fmt.Fprintf(out, "  Duration: %s\n", record.Duration)
+	fmt.Fprintf(out, "  State:    %s\n", record.StatePath)
+	fmt.Fprintf(out, "  Logs:     %s\n", record.LogDir)
+	if record.Error != "" {
+		fmt.Fprintf(out, "  Error:    %s\n", record.Error)
+	}
+
+	fmt.Fprintf(out, "\nTasks:\n")
+	for _, w := range record.Workflows {
+		fmt.Fprintf(out, "  %s\n", w.Name)
+		for _, t := range w.Tasks {
+			fmt.Fprintf(out, "    %s: %s", t.ID, t.Command)
+			if len(t.Dependencies) > 0 {
+				fmt.Fprintf(out, " (after %s)", strings.Join(t.Dependencies, ", "))
+			}
+			fmt.Fprintf(out, "\n")
+		}
+	}
+
+	fmt.Fprintf(out, "\nResults:\n")
+	printutils.WriteResults(out, record.Results, record.RunID)
+	return nil
+}
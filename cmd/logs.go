@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zackiles/task-graph-fs/internal/history"
+	"github.com/zackiles/task-graph-fs/internal/state"
+	"github.com/zackiles/task-graph-fs/internal/workspace"
+)
+
+// followInterval is how often a followed log file is polled for new output.
+const followInterval = 500 * time.Millisecond
+
+// NewLogsCmd creates and returns the "logs" command.
+func NewLogsCmd() *cobra.Command {
+	var opts struct {
+		workflowDir string
+		runID       string
+		state       string
+		follow      bool
+	}
+
+	logsCmd := &cobra.Command{
+		Use:   "logs <workflow>/<task>",
+		Short: "Show the output of a task",
+		Long: `The "logs" command prints the captured stdout and stderr of a task. By default
+it shows the log from the most recent apply; use --run to pick an earlier run
+and --follow to keep streaming output while the task is running. Following
+stops once the task has finished.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			return runLogs(ctx, cmd.OutOrStdout(), opts.workflowDir, opts.state, args[0], opts.runID, opts.follow)
+		},
+	}
+
+	logsCmd.Flags().StringVarP(&opts.workflowDir, "dir", "d", ".", "Directory containing workflows")
+	logsCmd.Flags().StringVar(&opts.runID, "run", "", "Run ID to show logs for (default: latest run)")
+	logsCmd.Flags().StringVar(&opts.state, "state", "", "State file to find the latest run in (default: tgfs-state.json in the workspace root)")
+	logsCmd.Flags().BoolVarP(&opts.follow, "follow", "f", false, "Keep printing new output until the task finishes")
+
+	return logsCmd
+}
+
+// runLogs contains the core logic for the "logs" command.
+func runLogs(ctx context.Context, out io.Writer, workflowDir, stateFile, taskRef, runID string, follow bool) error {
+	workflowName, taskID, err := splitTaskRef(taskRef)
+	if err != nil {
+		return err
+	}
+
+	stateFile, err = statePath(workflowDir, stateFile)
+	if err != nil {
+		return err
+	}
+	logPath, runID, err := resolveLogPath(ctx, workflowDir, stateFile, workflowName, taskID, runID)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no logs found for task %s", taskRef)
+		}
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(out, f); err != nil {
+		return fmt.Errorf("failed to read log file: %w", err)
+	}
+
+	if !follow {
+		return nil
+	}
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			// Check before reading, so that output written before the task
+			// finished is still printed
+			finished := taskFinished(ctx, workflowDir, stateFile, workflowName, taskID, runID)
+			if _, err := io.Copy(out, f); err != nil {
+				return fmt.Errorf("failed to read log file: %w", err)
+			}
+			if finished {
+				return nil
+			}
+		}
+	}
+}
+
+// taskFinished reports whether a followed task will write no more output to
+// its log in the given run: the run is over, or the state shows the task ended
+// in it. The state is saved shortly after each change, so a state still
+// showing an earlier run isn't taken to mean anything.
+func taskFinished(ctx context.Context, workflowDir, stateFile, workflowName, taskID, runID string) bool {
+	if _, err := os.Stat(history.RecordPath(workflowDir, runID)); err == nil {
+		return true
+	}
+
+	currentState, err := state.LoadStateFrom(ctx, stateFile)
+	if err != nil {
+		return false
+	}
+	w := currentState.FindWorkflow(workflowName)
+	if w == nil {
+		return false
+	}
+	t := w.FindTask(taskID)
+	if t == nil || t.RunID != runID {
+		return false
+	}
+	return t.Status != "running" && t.Status != "pending"
+}
+
+// splitTaskRef splits a "<workflow>/<task>" reference. Nested workflow names
+// may themselves contain slashes, so the task is the last path element.
+func splitTaskRef(ref string) (string, string, error) {
+	ref = strings.Trim(filepath.ToSlash(ref), "/")
+	idx := strings.LastIndex(ref, "/")
+	if idx <= 0 || idx == len(ref)-1 {
+		return "", "", fmt.Errorf("invalid task reference %q, expected <workflow>/<task>", ref)
+	}
+	return ref[:idx], strings.TrimSuffix(ref[idx+1:], ".md"), nil
+}
+
+// resolveLogPath finds the log file for a task and the run it belongs to,
+// using the state file to locate the latest run when no run ID is given.
+func resolveLogPath(ctx context.Context, workflowDir, stateFile, workflowName, taskID, runID string) (string, string, error) {
+	logPath := workspace.TaskLogPath(workflowName, taskID)
+	if runID != "" {
+		return filepath.Join(workspace.RunDir(workflowDir, runID), logPath), runID, nil
+	}
+
+	currentState, err := state.LoadStateFrom(ctx, stateFile)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load state: %w", err)
+	}
+	if currentState.RunID == "" {
+		return "", "", fmt.Errorf("no runs recorded yet, run `tgfs apply` first")
+	}
+
+	// A task resumed past in the latest run keeps the log of the run it ran in
+	runID = currentState.RunID
+	if w := currentState.FindWorkflow(workflowName); w != nil {
+		if t := w.FindTask(taskID); t != nil {
+			if t.RunID != "" {
+				runID = t.RunID
+			}
+			if t.LogPath != "" {
+				logPath = filepath.FromSlash(t.LogPath)
+			}
+		}
+	}
+
+	return filepath.Join(workspace.RunDir(workflowDir, runID), logPath), runID, nil
+}
@@ -2,12 +2,13 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"os"
+	"io"
 
 	"github.com/spf13/cobra"
 	"github.com/zackiles/task-graph-fs/internal/fsparse"
+	"github.com/zackiles/task-graph-fs/internal/printutils"
+	"github.com/zackiles/task-graph-fs/internal/report"
 	"github.com/zackiles/task-graph-fs/internal/services"
 )
 
@@ -26,29 +27,56 @@ func NewPlanCmd(parser *fsparse.Parser) *cobra.Command {
 
 	var opts struct {
 		workflowDir string
+		out         string
+		state       string
+		output      string
+		parse       parseFlags
 	}
 
 	planCmd := &cobra.Command{
 		Use:   "plan",
 		Short: "Plan workflow execution",
-		Long:  `The "plan" command analyzes workflows and creates an execution plan.`,
-		Args:  cobra.NoArgs,
+		Long: `The "plan" command analyzes workflows and creates an execution plan. The
+plan, with every resolved task spec and dependency edge, is saved to --out so
+that "tgfs apply <planfile>" can execute exactly what was reviewed.
+
+With --output json the plan is printed as a single JSON document, described in
+the README, instead of text.`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
-			return runPlan(ctx, parser, opts.workflowDir)
+			if err := report.CheckFormat(opts.output); err != nil {
+				return err
+			}
+			return runPlan(ctx, cmd.OutOrStdout(), parser, opts.workflowDir, opts.state, opts.out, opts.output, opts.parse)
 		},
 	}
 
 	planCmd.Flags().StringVarP(&opts.workflowDir, "dir", "d", ".", "Directory containing workflows")
+	planCmd.Flags().StringVar(&opts.out, "out", "", "File to save the plan to (default: .tgfs-plan in the workspace root)")
+	planCmd.Flags().StringVar(&opts.state, "state", "", "State file to plan against (default: tgfs-state.json in the workspace root)")
+	planCmd.Flags().StringVarP(&opts.output, "output", "o", report.FormatText, "Output format: text or json")
+	opts.parse.register(planCmd.Flags())
 	return planCmd
 }
 
 // runPlan contains the core logic for the "plan" command.
-func runPlan(ctx context.Context, parser *fsparse.Parser, workflowDir string) error {
+func runPlan(ctx context.Context, w io.Writer, parser *fsparse.Parser, workflowDir, stateFile, out, format string, parse parseFlags) error {
 	if parser == nil {
 		return fmt.Errorf("parser is required")
 	}
 
+	stateFile, err := statePath(workflowDir, stateFile)
+	if err != nil {
+		return err
+	}
+	out = planOutPath(workflowDir, out)
+
+	parser, err = workspaceParser(parser, workflowDir, parse)
+	if err != nil {
+		return err
+	}
+
 	applyService := services.NewApplyService(parser)
 	if applyService == nil {
 		return fmt.Errorf("failed to create apply service")
@@ -56,6 +84,7 @@ func runPlan(ctx context.Context, parser *fsparse.Parser, workflowDir string) er
 
 	result, err := applyService.Plan(ctx, services.ApplyOptions{
 		WorkflowDir: workflowDir,
+		StatePath:   stateFile,
 	})
 	if err != nil {
 		// Don't create plan file if there's an error
@@ -63,30 +92,36 @@ func runPlan(ctx context.Context, parser *fsparse.Parser, workflowDir string) er
 	}
 
 	// Only create plan file if planning was successful
-	planData := map[string]interface{}{
-		"added":      result.Added,
-		"updated":    result.Updated,
-		"removed":    result.Removed,
-		"hasChanges": result.HasChanges,
+	plan, err := result.SavedPlan(workflowDir)
+	if err != nil {
+		return err
+	}
+	if err := plan.Write(out); err != nil {
+		return err
 	}
 
-	planJSON, err := json.MarshalIndent(planData, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal plan data: %w", err)
+	if format == report.FormatJSON {
+		return report.WritePlan(w, report.NewPlan(result.Diff, result.Warnings, out))
 	}
 
-	if err := os.WriteFile(".tgfs-plan", planJSON, 0o644); err != nil {
-		return fmt.Errorf("failed to write plan file: %w", err)
+	// Print the task-level changes and a summary
+	fmt.Println()
+	printutils.PrintPlan(result.Diff)
+
+	if len(result.Warnings) > 0 {
+		fmt.Println("Warnings:")
+		for _, warning := range result.Warnings {
+			fmt.Printf("  %s\n", warning)
+		}
+		fmt.Println()
 	}
 
-	// Print plan summary
-	fmt.Println("\nPlan Summary:")
-	fmt.Printf("  Added:   %d\n", len(result.Added))
-	fmt.Printf("  Updated: %d\n", len(result.Updated))
-	fmt.Printf("  Removed: %d\n", len(result.Removed))
+	printutils.PrintPlanSummary(result.Diff)
 
 	if !result.HasChanges {
 		fmt.Println("\nNo changes to apply")
+	} else {
+		fmt.Printf("\nSaved the plan to %s. Run `tgfs apply %s` to execute exactly this plan.\n", out, out)
 	}
 
 	return nil
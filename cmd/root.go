@@ -13,12 +13,25 @@ func NewRootCommand() *cobra.Command {
 		Short: "Filesystem-based task orchestration",
 		Long: `TaskGraphFS (tgfs) is a tool for defining and executing task workflows
 using a filesystem-based approach with markdown files and symbolic links.`,
+		// main reports the error once
+		SilenceErrors: true,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// Usage only helps with mistakes in the arguments, which have
+			// been checked by now
+			cmd.SilenceUsage = true
+		},
 	}
 
 	rootCmd.AddCommand(
 		NewInitCmd(),
 		NewPlanCmd(parser),
 		NewApplyCmd(parser),
+		NewLogsCmd(),
+		NewValidateCmd(parser),
+		NewCacheCmd(),
+		NewForceUnlockCmd(),
+		NewHistoryCmd(),
+		NewShowCmd(),
 	)
 
 	return rootCmd
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zackiles/task-graph-fs/internal/state"
+)
+
+// NewForceUnlockCmd creates and returns the "force-unlock" command.
+func NewForceUnlockCmd() *cobra.Command {
+	var opts struct {
+		workflowDir string
+		state       string
+	}
+
+	unlockCmd := &cobra.Command{
+		Use:   "force-unlock <lock-id>",
+		Short: "Release a stuck state lock",
+		Long: `The "force-unlock" command removes the lock that "apply" holds on the state
+file while it runs. Use it only when the process holding the lock is gone and
+the lock was left behind; an apply that is still running keeps going and will
+still write the state. The lock ID is printed by the apply that was refused.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runForceUnlock(cmd.OutOrStdout(), opts.workflowDir, opts.state, args[0])
+		},
+	}
+
+	unlockCmd.Flags().StringVarP(&opts.workflowDir, "dir", "d", ".", "Directory containing workflows")
+	unlockCmd.Flags().StringVar(&opts.state, "state", "", "State file to unlock (default: tgfs-state.json in the workspace root)")
+	return unlockCmd
+}
+
+// runForceUnlock contains the core logic for the "force-unlock" command.
+func runForceUnlock(out io.Writer, workflowDir, stateFile, lockID string) error {
+	stateFile, err := statePath(workflowDir, stateFile)
+	if err != nil {
+		return err
+	}
+
+	info, err := state.ForceUnlock(stateFile, lockID)
+	if err != nil {
+		return fmt.Errorf("failed to unlock the state: %w", err)
+	}
+	fmt.Fprintf(out, "Released lock %s held by %s (pid %d on %s, since %s)\n",
+		info.ID, info.Operation, info.PID, info.Host, info.CreatedAt.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"github.com/zackiles/task-graph-fs/internal/fsparse"
+)
+
+// NewValidateCmd creates and returns the "validate" command.
+func NewValidateCmd(parser *fsparse.Parser) *cobra.Command {
+	var opts struct {
+		workflowDir string
+		parse       parseFlags
+	}
+
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the workflow graph for problems",
+		Long: `The "validate" command checks the workflow graph for dangling symlinks, symlinks
+pointing outside the workspace, self-dependencies, dependencies on unknown tasks,
+duplicate task IDs and dependency cycles. Every problem is reported with the
+offending file path and the command exits non-zero if any are found. The same
+checks run automatically at the start of "plan" and "apply".`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			return runValidate(ctx, cmd.OutOrStdout(), parser, opts.workflowDir, opts.parse)
+		},
+	}
+
+	validateCmd.Flags().StringVarP(&opts.workflowDir, "dir", "d", ".", "Directory containing workflows")
+	opts.parse.register(validateCmd.Flags())
+	return validateCmd
+}
+
+// runValidate contains the core logic for the "validate" command.
+func runValidate(ctx context.Context, out io.Writer, parser *fsparse.Parser, workflowDir string, parse parseFlags) error {
+	parser, err := workspaceParser(parser, workflowDir, parse)
+	if err != nil {
+		return err
+	}
+
+	workflows, err := parser.Validate(ctx, workflowDir)
+
+	var validationErr *fsparse.ValidationError
+	if errors.As(err, &validationErr) {
+		fmt.Fprintf(out, "Found %d problem(s):\n", len(validationErr.Problems))
+		for _, p := range validationErr.Problems {
+			fmt.Fprintf(out, "  %s\n", p)
+		}
+		return fmt.Errorf("validation failed with %d problem(s)", len(validationErr.Problems))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to validate workflows: %w", err)
+	}
+
+	tasks := 0
+	for _, w := range workflows {
+		tasks += len(w.Tasks)
+	}
+	fmt.Fprintf(out, "Workflow graph is valid: %d workflow(s), %d task(s)\n", len(workflows), tasks)
+	return nil
+}
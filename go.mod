@@ -2,11 +2,12 @@ module github.com/zackiles/task-graph-fs
 
 go 1.22
 
-require github.com/spf13/cobra v1.8.1
-
 require (
-	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/spf13/cobra v1.8.1
+	github.com/spf13/pflag v1.0.5
+	gopkg.in/yaml.v3 v3.0.1
 )
 
+require github.com/inconshreveable/mousetrap v1.1.0 // indirect
+
 replace github.com/zackiles/task-graph-fs => ./
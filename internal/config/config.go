@@ -0,0 +1,154 @@
+// Package config loads the optional workspace configuration file, tgfs.yaml,
+// from the root of a workspace.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zackiles/task-graph-fs/internal/state"
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the configuration file in the workspace root
+const FileName = "tgfs.yaml"
+
+// Extraction providers that can be selected in the configuration
+const (
+	ProviderGopilot = "gopilot"
+	ProviderOpenAI  = "openai"
+)
+
+// Config is the workspace configuration. Every setting is optional.
+type Config struct {
+	Extractor ExtractorConfig `yaml:"extractor"`
+	State     StateConfig     `yaml:"state"`
+	History   HistoryConfig   `yaml:"history"`
+	// MaxRunning caps how many tasks an apply runs at once across all
+	// workflows; zero means no limit
+	MaxRunning int `yaml:"max_running"`
+	// Workflows holds per-workflow settings, keyed by workflow name
+	Workflows map[string]WorkflowConfig `yaml:"workflows"`
+}
+
+// WorkflowConfig configures how a single workflow runs
+type WorkflowConfig struct {
+	// Timeout bounds the workflow's run in each apply; zero means no limit
+	Timeout Duration `yaml:"timeout"`
+	// MaxRunning caps how many of the workflow's tasks run at once; zero
+	// means no limit
+	MaxRunning int `yaml:"max_running"`
+}
+
+// HistoryConfig limits how many run records, and their logs, are kept under
+// .tgfs/runs. Zero values keep every run.
+type HistoryConfig struct {
+	// Keep is the number of most recent runs to keep
+	Keep int `yaml:"keep"`
+	// MaxAge removes runs older than this
+	MaxAge Duration `yaml:"max_age"`
+}
+
+// StateConfig configures where the workspace's state is kept
+type StateConfig struct {
+	// Path is the state file, relative to the workspace root unless absolute
+	Path string `yaml:"path"`
+}
+
+// ExtractorConfig selects how free-form tasks are turned into task properties.
+// An empty provider keeps the default gopilot provider.
+type ExtractorConfig struct {
+	Provider string        `yaml:"provider"`
+	Gopilot  GopilotConfig `yaml:"gopilot"`
+	OpenAI   OpenAIConfig  `yaml:"openai"`
+}
+
+// GopilotConfig configures the gopilot subprocess provider
+type GopilotConfig struct {
+	// Path is the gopilot binary to run
+	Path string `yaml:"path"`
+}
+
+// OpenAIConfig configures the OpenAI-compatible chat completions provider
+type OpenAIConfig struct {
+	BaseURL string `yaml:"base_url"`
+	Model   string `yaml:"model"`
+	// APIKeyEnv names the environment variable holding the API key
+	APIKeyEnv string   `yaml:"api_key_env"`
+	Timeout   Duration `yaml:"timeout"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" or "5m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q", value.Line, value.Value)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Load reads the configuration from the given workspace root. A workspace
+// without a configuration file gets the zero Config.
+func Load(dir string) (*Config, error) {
+	path := filepath.Join(dir, FileName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", FileName, err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", FileName, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", FileName, err)
+	}
+	return &cfg, nil
+}
+
+// StatePath returns the state file of the workspace at root: the configured
+// path, or tgfs-state.json in the workspace root
+func (c *Config) StatePath(root string) string {
+	switch {
+	case c.State.Path == "":
+		return filepath.Join(root, state.DefaultFileName)
+	case filepath.IsAbs(c.State.Path):
+		return c.State.Path
+	default:
+		return filepath.Join(root, c.State.Path)
+	}
+}
+
+func (c *Config) validate() error {
+	if c.History.Keep < 0 || c.History.MaxAge < 0 {
+		return fmt.Errorf("history keep and max_age can't be negative")
+	}
+	if c.MaxRunning < 0 {
+		return fmt.Errorf("max_running can't be negative")
+	}
+	for name, w := range c.Workflows {
+		if w.Timeout < 0 || w.MaxRunning < 0 {
+			return fmt.Errorf("workflow %s: timeout and max_running can't be negative", name)
+		}
+	}
+
+	switch c.Extractor.Provider {
+	case "", ProviderGopilot:
+	case ProviderOpenAI:
+		if c.Extractor.OpenAI.BaseURL == "" || c.Extractor.OpenAI.Model == "" {
+			return fmt.Errorf("the openai extractor requires base_url and model")
+		}
+	default:
+		return fmt.Errorf("unknown extractor provider %q, expected %s or %s", c.Extractor.Provider, ProviderGopilot, ProviderOpenAI)
+	}
+	return nil
+}
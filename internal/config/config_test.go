@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Extractor.Provider != "" {
+		t.Errorf("expected no provider without a config file, got %q", cfg.Extractor.Provider)
+	}
+
+	content := "extractor:\n  provider: openai\n  openai:\n    base_url: http://localhost:11434/v1\n    model: llama3\n    api_key_env: OPENAI_API_KEY\n    timeout: 2m\n"
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openAI := cfg.Extractor.OpenAI
+	if cfg.Extractor.Provider != ProviderOpenAI || openAI.BaseURL != "http://localhost:11434/v1" || openAI.Model != "llama3" || openAI.APIKeyEnv != "OPENAI_API_KEY" {
+		t.Errorf("unexpected config %+v", cfg.Extractor)
+	}
+	if time.Duration(openAI.Timeout) != 2*time.Minute {
+		t.Errorf("expected a 2m timeout, got %v", time.Duration(openAI.Timeout))
+	}
+
+	content = "max_running: 8\nworkflows:\n  train:\n    timeout: 6h\n    max_running: 2\n"
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeout := time.Duration(cfg.Workflows["train"].Timeout); timeout != 6*time.Hour {
+		t.Errorf("expected a 6h workflow timeout, got %v", timeout)
+	}
+	if cfg.MaxRunning != 8 {
+		t.Errorf("expected max_running of 8, got %d", cfg.MaxRunning)
+	}
+	if maxRunning := cfg.Workflows["train"].MaxRunning; maxRunning != 2 {
+		t.Errorf("expected a workflow max_running of 2, got %d", maxRunning)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown extractor provider":  "extractor:\n  provider: magic\n",
+		"requires base_url and model": "extractor:\n  provider: openai\n",
+		"invalid duration":            "extractor:\n  openai:\n    timeout: soon\n",
+		"can't be negative":           "workflows:\n  train:\n    timeout: -1h\n",
+		"max_running can't be":        "max_running: -1\n",
+	}
+
+	for want, content := range tests {
+		t.Run(want, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, FileName), []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(dir); err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("expected error containing %q, got %v", want, err)
+			}
+		})
+	}
+}
+
+func TestStatePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", filepath.Join("ws", "tgfs-state.json")},
+		{"state/ci.json", filepath.Join("ws", "state", "ci.json")},
+		{"/var/lib/tgfs/state.json", "/var/lib/tgfs/state.json"},
+	}
+
+	for _, tt := range tests {
+		cfg := &Config{State: StateConfig{Path: tt.path}}
+		if got := cfg.StatePath("ws"); got != tt.want {
+			t.Errorf("StatePath with path %q: expected %q, got %q", tt.path, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package fsparse
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// taskProperties holds the task properties spelled out in a task file, either
+// in its YAML front matter or in the documented "## Command",
+// "## Dependencies", "## Priority", "## Retries" and "## Timeout" sections.
+// A nil field means the property is missing.
+type taskProperties struct {
+	Command      *string
+	Dependencies *[]string
+	Priority     *string
+	Retries      *int
+	Timeout      *string
+	Env          map[string]string
+	Workdir      *string
+	// Front holds the front matter's own properties, nil when the file has
+	// no front matter
+	Front *taskProperties
+}
+
+// complete reports whether the file spells out a command, in which case the
+// task can be parsed without consulting gopilot and the properties it leaves
+// out take their defaults
+func (s taskProperties) complete() bool {
+	return s.Command != nil
+}
+
+// setDefaults fills in every missing property but the command: no
+// dependencies beyond the task's symlinks, medium priority, no retries and
+// no timeout
+func (s *taskProperties) setDefaults() {
+	if s.Dependencies == nil {
+		s.Dependencies = &[]string{}
+	}
+	if s.Priority == nil {
+		priority := "medium"
+		s.Priority = &priority
+	}
+	if s.Retries == nil {
+		retries := 0
+		s.Retries = &retries
+	}
+	if s.Timeout == nil {
+		// An empty timeout lets the task run until it finishes
+		timeout := ""
+		s.Timeout = &timeout
+	}
+}
+
+// apply copies every property that is present onto the task
+func (s taskProperties) apply(task *Task) {
+	if s.Command != nil {
+		task.Command = *s.Command
+	}
+	if s.Dependencies != nil {
+		task.Dependencies = *s.Dependencies
+	}
+	if s.Priority != nil {
+		task.Priority = *s.Priority
+	}
+	if s.Retries != nil {
+		task.Retries = *s.Retries
+	}
+	if s.Timeout != nil {
+		task.Timeout = *s.Timeout
+	}
+	if s.Env != nil {
+		task.Env = s.Env
+	}
+	if s.Workdir != nil {
+		task.Workdir = *s.Workdir
+	}
+}
+
+// parseTaskProperties reads a task file's front matter and heading sections.
+// Front matter takes precedence, and every property the two define
+// differently is returned as a warning.
+func parseTaskProperties(content []byte) (taskProperties, []string, error) {
+	frontContent, body, ok := splitFrontMatter(content)
+	if !ok {
+		sections, err := parseTaskSections(content)
+		return sections, nil, err
+	}
+
+	front, err := parseFrontMatter(frontContent)
+	if err != nil {
+		return taskProperties{}, nil, err
+	}
+	sections, err := parseTaskSections(body)
+	if err != nil {
+		return taskProperties{}, nil, err
+	}
+
+	var warnings []string
+	conflict := func(name, frontValue, sectionValue string) {
+		if frontValue != sectionValue {
+			warnings = append(warnings, fmt.Sprintf(
+				"front matter %s %q overrides the ## %s section %q",
+				strings.ToLower(name), frontValue, name, sectionValue,
+			))
+		}
+	}
+
+	merged := sections
+	if front.Command != nil {
+		if sections.Command != nil {
+			conflict("Command", *front.Command, *sections.Command)
+		}
+		merged.Command = front.Command
+	}
+	if front.Dependencies != nil {
+		if sections.Dependencies != nil {
+			conflict("Dependencies", strings.Join(*front.Dependencies, ", "), strings.Join(*sections.Dependencies, ", "))
+		}
+		merged.Dependencies = front.Dependencies
+	}
+	if front.Priority != nil {
+		if sections.Priority != nil {
+			conflict("Priority", *front.Priority, *sections.Priority)
+		}
+		merged.Priority = front.Priority
+	}
+	if front.Retries != nil {
+		if sections.Retries != nil {
+			conflict("Retries", strconv.Itoa(*front.Retries), strconv.Itoa(*sections.Retries))
+		}
+		merged.Retries = front.Retries
+	}
+	if front.Timeout != nil {
+		if sections.Timeout != nil {
+			conflict("Timeout", *front.Timeout, *sections.Timeout)
+		}
+		merged.Timeout = front.Timeout
+	}
+	merged.Env = front.Env
+	merged.Workdir = front.Workdir
+	merged.Front = &front
+
+	return merged, warnings, nil
+}
+
+// frontMatter is the YAML block accepted at the top of a task file
+type frontMatter struct {
+	Command      *string           `yaml:"command"`
+	Dependencies *[]string         `yaml:"dependencies"`
+	Priority     *string           `yaml:"priority"`
+	Retries      *int              `yaml:"retries"`
+	Timeout      *string           `yaml:"timeout"`
+	Env          map[string]string `yaml:"env"`
+	Workdir      *string           `yaml:"workdir"`
+}
+
+// splitFrontMatter separates a leading "---" delimited YAML block from the
+// rest of a task file. A block that is never closed isn't front matter.
+func splitFrontMatter(content []byte) ([]byte, []byte, bool) {
+	content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	if !bytes.HasPrefix(content, []byte("---\n")) {
+		return nil, content, false
+	}
+
+	rest := content[len("---\n"):]
+	offset := 0
+	for _, line := range bytes.SplitAfter(rest, []byte("\n")) {
+		trimmed := strings.TrimSpace(string(line))
+		if trimmed == "---" || trimmed == "..." {
+			return rest[:offset], rest[offset+len(line):], true
+		}
+		offset += len(line)
+	}
+	return nil, content, false
+}
+
+// parseFrontMatter decodes a task's YAML front matter, validating values the
+// same way as the heading sections
+func parseFrontMatter(content []byte) (taskProperties, error) {
+	var fm frontMatter
+	if err := yaml.Unmarshal(content, &fm); err != nil {
+		return taskProperties{}, fmt.Errorf("invalid front matter: %w", err)
+	}
+
+	props := taskProperties{Env: fm.Env}
+	if fm.Command != nil {
+		command := strings.TrimSpace(*fm.Command)
+		if command == "" {
+			return props, fmt.Errorf("the front matter command is empty")
+		}
+		props.Command = &command
+	}
+	if fm.Dependencies != nil {
+		deps := parseDependencyList(strings.Join(*fm.Dependencies, "\n"))
+		props.Dependencies = &deps
+	}
+	if fm.Priority != nil {
+		priority, err := parsePriority(*fm.Priority)
+		if err != nil {
+			return props, err
+		}
+		props.Priority = &priority
+	}
+	if fm.Retries != nil {
+		if *fm.Retries < 0 {
+			return props, fmt.Errorf("invalid retries %d, expected a non-negative number", *fm.Retries)
+		}
+		props.Retries = fm.Retries
+	}
+	if fm.Timeout != nil {
+		timeout, err := parseTimeout(*fm.Timeout)
+		if err != nil {
+			return props, err
+		}
+		props.Timeout = &timeout
+	}
+	if fm.Workdir != nil {
+		workdir := strings.TrimSpace(*fm.Workdir)
+		props.Workdir = &workdir
+	}
+
+	return props, nil
+}
+
+// parseTaskSections extracts the documented sections from a task file. Values
+// that are present but malformed are reported as errors rather than silently
+// handed to gopilot.
+func parseTaskSections(content []byte) (taskProperties, error) {
+	var sections taskProperties
+
+	bodies := splitSections(content)
+	for _, heading := range []string{"command", "dependencies", "priority", "retries", "timeout"} {
+		body, ok := bodies[heading]
+		if !ok {
+			continue
+		}
+
+		switch heading {
+		case "command":
+			command := stripCodeFence(body)
+			if command == "" {
+				return sections, fmt.Errorf("the Command section is empty")
+			}
+			sections.Command = &command
+		case "dependencies":
+			deps := parseDependencyList(body)
+			sections.Dependencies = &deps
+		case "priority":
+			priority, err := parsePriority(body)
+			if err != nil {
+				return sections, err
+			}
+			sections.Priority = &priority
+		case "retries":
+			retries, err := strconv.Atoi(strings.TrimSpace(body))
+			if err != nil || retries < 0 {
+				return sections, fmt.Errorf("invalid retries %q, expected a non-negative number", body)
+			}
+			sections.Retries = &retries
+		case "timeout":
+			timeout, err := parseTimeout(body)
+			if err != nil {
+				return sections, err
+			}
+			sections.Timeout = &timeout
+		}
+	}
+
+	return sections, nil
+}
+
+// parsePriority normalises a task priority, which must be high, medium or low
+func parsePriority(value string) (string, error) {
+	priority := strings.ToLower(strings.TrimSpace(value))
+	switch priority {
+	case "high", "medium", "low":
+		return priority, nil
+	default:
+		return "", fmt.Errorf("invalid priority %q, expected high, medium or low", value)
+	}
+}
+
+// parseTimeout checks that a task timeout is a valid duration
+func parseTimeout(value string) (string, error) {
+	timeout := strings.TrimSpace(value)
+	if _, err := time.ParseDuration(timeout); err != nil {
+		return "", fmt.Errorf("invalid timeout %q, expected a duration such as 30m", value)
+	}
+	return timeout, nil
+}
+
+// splitSections returns the body of every level-two heading keyed by its
+// lower-cased title. Headings inside fenced code blocks are ignored.
+func splitSections(content []byte) map[string]string {
+	sections := make(map[string]string)
+
+	var heading string
+	var body []string
+	inFence := false
+
+	flush := func() {
+		if heading != "" {
+			sections[heading] = strings.TrimSpace(strings.Join(body, "\n"))
+		}
+		body = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		}
+
+		if !inFence && strings.HasPrefix(trimmed, "#") {
+			level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+			flush()
+			heading = ""
+			if level == 2 {
+				heading = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(trimmed[level:]), ":"))
+			}
+			continue
+		}
+
+		if heading != "" {
+			body = append(body, line)
+		}
+	}
+	flush()
+
+	return sections
+}
+
+// stripCodeFence returns the contents of a section, unwrapping a fenced code
+// block if the section consists of one
+func stripCodeFence(body string) string {
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) >= 2 {
+		first := strings.TrimSpace(lines[0])
+		last := strings.TrimSpace(lines[len(lines)-1])
+		if (strings.HasPrefix(first, "```") && last == "```") || (strings.HasPrefix(first, "~~~") && last == "~~~") {
+			lines = lines[1 : len(lines)-1]
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// parseDependencyList reads task names from a Dependencies section. Names may
+// be separated by commas or newlines, written as list items or code spans,
+// and may include the .md extension. "None" means no dependencies.
+func parseDependencyList(body string) []string {
+	deps := []string{}
+	for _, line := range strings.Split(body, "\n") {
+		for _, item := range strings.Split(line, ",") {
+			item = strings.TrimSpace(item)
+			item = strings.TrimSpace(strings.TrimLeft(item, "-*+"))
+			item = strings.Trim(item, "`")
+			item = strings.TrimSuffix(item, ".md")
+			if item == "" || strings.EqualFold(item, "none") {
+				continue
+			}
+			deps = append(deps, item)
+		}
+	}
+	return deps
+}
@@ -2,39 +2,115 @@ package fsparse
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/zackiles/task-graph-fs/internal/gopilotcli"
 )
 
+// DefaultParallelism is how many tasks are extracted at once unless the
+// parser is told otherwise
+var DefaultParallelism = runtime.NumCPU()
+
 type Parser struct {
-	gopilot gopilotcli.GopilotCLI
+	extractor   gopilotcli.Extractor
+	parallelism int
 }
 
 // NewParser creates a new parser using the current gopilot provider
 func NewParser() *Parser {
-	return &Parser{
-		gopilot: gopilotcli.GetProvider(),
-	}
+	return NewParserWithExtractor(gopilotcli.NewExtractor(gopilotcli.GetProvider()))
 }
 
 // NewParserWithGopilot creates a new parser with a specific gopilot implementation
 // This is deprecated in favor of using the provider pattern
 func NewParserWithGopilot(gopilot gopilotcli.GopilotCLI) *Parser {
+	return NewParserWithExtractor(gopilotcli.NewExtractor(gopilot))
+}
+
+// NewParserWithExtractor creates a new parser that extracts the properties of
+// free-form tasks with the given extractor
+func NewParserWithExtractor(extractor gopilotcli.Extractor) *Parser {
 	return &Parser{
-		gopilot: gopilot,
+		extractor: extractor,
+	}
+}
+
+// SetParallelism bounds how many tasks are extracted at once across the whole
+// workspace. Values below one restore DefaultParallelism.
+func (p *Parser) SetParallelism(n int) {
+	p.parallelism = n
+}
+
+func (p *Parser) workers() int {
+	if p.parallelism < 1 {
+		return DefaultParallelism
 	}
+	return p.parallelism
 }
 
-// ParseWorkflows walks through the given base path and constructs Workflow objects
+// Extractor returns the extractor the parser consults for free-form tasks
+func (p *Parser) Extractor() gopilotcli.Extractor {
+	return p.extractor
+}
+
+// ParseWorkflows walks through the given base path and constructs Workflow objects.
+// Broken dependency links are reported together as a *ValidationError.
 func (p *Parser) ParseWorkflows(ctx context.Context, basePath string) ([]Workflow, error) {
-	var workflows []Workflow
+	workflows, problems, err := p.parseWorkspace(ctx, basePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return workflows, nil
+}
+
+// Validate parses the workflows under the given base path and checks the
+// resulting graph. Every problem found, from broken symlinks to dependency
+// cycles, is returned in a single *ValidationError alongside the workflows
+// that could be parsed.
+func (p *Parser) Validate(ctx context.Context, basePath string) ([]Workflow, error) {
+	workflows, problems, err := p.parseWorkspace(ctx, basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	problems = append(problems, ValidateGraph(workflows)...)
+	if len(problems) > 0 {
+		return workflows, &ValidationError{Problems: problems}
+	}
+	return workflows, nil
+}
+
+// parseWorkspace parses every workflow under the base path, collecting broken
+// dependency links as problems rather than stopping at the first one.
+// Workflows and their tasks are parsed concurrently, with at most
+// p.workers() task extractions running at once, and results are returned in
+// directory order.
+func (p *Parser) parseWorkspace(ctx context.Context, basePath string) ([]Workflow, []Problem, error) {
+	type workflowDir struct {
+		path string
+		name string
+	}
+	var dirs []workflowDir
+
+	// Dependency symlinks are resolved against the real workspace root
+	root, err := workspaceRoot(basePath)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Walk through all directories recursively
-	err := filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -48,88 +124,413 @@ func (p *Parser) ParseWorkflows(ctx context.Context, basePath string) ([]Workflo
 				return nil
 			}
 
-			// Skip hidden directories and non-workflow directories
+			// Skip hidden directories, non-workflow directories and fan-in
+			// dependency directories, which belong to their parent workflow
 			if strings.HasPrefix(info.Name(), ".") || isProjectDirectory(info.Name()) {
 				return filepath.SkipDir
 			}
+			if _, ok := dependencySource(info.Name()); ok {
+				return filepath.SkipDir
+			}
 
 			// Check if directory contains any .md files
 			if containsMarkdownFiles(path) {
-				workflow, err := p.parseWorkflow(ctx, path)
+				// The path relative to the base path is the workflow name
+				name, err := filepath.Rel(basePath, path)
 				if err != nil {
-					return fmt.Errorf("failed to parse workflow %s: %w", path, err)
+					return fmt.Errorf("failed to resolve workflow name for %s: %w", path, err)
 				}
-				// Set the relative path as the workflow name
-				workflow.Name = strings.TrimPrefix(path, basePath+string(os.PathSeparator))
-				workflows = append(workflows, workflow)
+				dirs = append(dirs, workflowDir{path: path, name: filepath.ToSlash(name)})
 			}
 
 			return nil
 		}
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to walk directory: %w", err)
+		return nil, nil, fmt.Errorf("failed to walk directory: %w", err)
 	}
 
-	return workflows, nil
+	workflows := make([]Workflow, len(dirs))
+	linkProblems := make([][]Problem, len(dirs))
+	sem := make(chan struct{}, p.workers())
+
+	err = forEach(ctx, len(dirs), func(ctx context.Context, i int) error {
+		workflow, problems, err := p.parseWorkflow(ctx, root, dirs[i].path, dirs[i].name, sem)
+		if err != nil {
+			return fmt.Errorf("failed to parse workflow %s: %w", dirs[i].path, err)
+		}
+		workflows[i] = workflow
+		linkProblems[i] = problems
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	var problems []Problem
+	for _, p := range linkProblems {
+		problems = append(problems, p...)
+	}
+	return workflows, problems, nil
 }
 
-func (p *Parser) parseWorkflow(ctx context.Context, workflowPath string) (Workflow, error) {
+// parseWorkflow parses a single workflow directory. Task extraction holds a
+// slot in sem for as long as it runs.
+func (p *Parser) parseWorkflow(ctx context.Context, root, workflowPath, name string, sem chan struct{}) (Workflow, []Problem, error) {
 	select {
 	case <-ctx.Done():
-		return Workflow{}, ctx.Err()
+		return Workflow{}, nil, ctx.Err()
 	default:
 		workflow := Workflow{
-			Name:         filepath.Base(workflowPath),
+			Name:         name,
+			Path:         workflowPath,
 			Dependencies: make(map[string][]string),
 		}
 
 		entries, err := os.ReadDir(workflowPath)
 		if err != nil {
-			return Workflow{}, fmt.Errorf("failed to read workflow directory: %w", err)
+			return Workflow{}, nil, fmt.Errorf("failed to read workflow directory: %w", err)
 		}
 
+		var problems []Problem
+		linkPaths := make(map[string]string)
+		addEdge := func(sourceTask, linkPath string) {
+			targetTask, err := resolveDependencyLink(root, name, linkPath)
+			if err != nil {
+				problems = append(problems, Problem{Path: linkPath, Message: err.Error()})
+				return
+			}
+			if targetTask == sourceTask {
+				problems = append(problems, Problem{Path: linkPath, Message: fmt.Sprintf("task %s depends on itself", sourceTask)})
+				return
+			}
+			workflow.Dependencies[sourceTask] = append(workflow.Dependencies[sourceTask], targetTask)
+		}
+
+		var taskFiles []string
 		for _, entry := range entries {
 			if !strings.HasSuffix(entry.Name(), ".md") {
-				// Check if it's a symlink representing dependencies
-				if entry.Type()&os.ModeSymlink != 0 {
-					sourceTask := strings.TrimSuffix(entry.Name(), "_dependencies")
-					target, err := os.Readlink(filepath.Join(workflowPath, entry.Name()))
+				// Check if it's a symlink or directory representing dependencies
+				sourceTask, ok := dependencySource(entry.Name())
+				if !ok {
+					continue
+				}
+
+				entryPath := filepath.Join(workflowPath, entry.Name())
+				switch {
+				case entry.Type()&os.ModeSymlink != 0:
+					linkPaths[sourceTask] = entryPath
+					addEdge(sourceTask, entryPath)
+				case entry.IsDir():
+					links, err := readDependencyDir(entryPath)
 					if err != nil {
-						return Workflow{}, fmt.Errorf("failed to read symlink: %w", err)
+						return Workflow{}, nil, err
+					}
+					linkPaths[sourceTask] = entryPath
+					for _, link := range links {
+						addEdge(sourceTask, link)
 					}
-					targetTask := strings.TrimSuffix(filepath.Base(target), ".md")
-					workflow.Dependencies[sourceTask] = append(
-						workflow.Dependencies[sourceTask],
-						targetTask,
-					)
 				}
 				continue
 			}
 
-			taskPath := filepath.Join(workflowPath, entry.Name())
+			taskFiles = append(taskFiles, entry.Name())
+		}
+
+		// Validate tasks by parsing their properties
+		workflow.Tasks = make([]Task, len(taskFiles))
+		err = forEach(ctx, len(taskFiles), func(ctx context.Context, i int) error {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 
-			// Validate task by parsing its properties
-			command, deps, priority, retries, timeout, err := p.gopilot.GenerateTaskProps(ctx, taskPath)
+			task, err := p.parseTask(ctx, filepath.Join(workflowPath, taskFiles[i]))
 			if err != nil {
-				return Workflow{}, fmt.Errorf("failed to parse task %s: %w", entry.Name(), err)
+				return fmt.Errorf("failed to parse task %s: %w", taskFiles[i], err)
+			}
+			workflow.Tasks[i] = task
+			return nil
+		})
+		if err != nil {
+			return Workflow{}, nil, err
+		}
+
+		workflow.canonicalizeDependencies()
+
+		// Dependency links must belong to a task of this workflow
+		for sourceTask, linkPath := range linkPaths {
+			if !workflow.hasTask(sourceTask) {
+				problems = append(problems, Problem{
+					Path:    linkPath,
+					Message: fmt.Sprintf("dependency link for unknown task %s", sourceTask),
+				})
+			}
+		}
+		sortProblems(problems)
+
+		return workflow, problems, nil
+	}
+}
+
+// forEach calls fn for every index in [0, n) concurrently and waits for them
+// all. The first failure cancels the context passed to the remaining calls.
+// The error returned is that of the lowest failing index, ignoring calls that
+// only failed because of that cancellation, so results don't depend on
+// scheduling.
+func forEach(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if errs[i] = fn(ctx, i); errs[i] != nil {
+				cancel()
 			}
+		}(i)
+	}
+	wg.Wait()
 
-			task := Task{
-				ID:           strings.TrimSuffix(entry.Name(), ".md"),
-				MarkdownPath: taskPath,
-				Command:      command,
-				Dependencies: deps,
-				Priority:     priority,
-				Retries:      retries,
-				Timeout:      timeout,
-				Status:       "pending",
+	var canceled error
+	for _, err := range errs {
+		switch {
+		case err == nil:
+		case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+			if canceled == nil {
+				canceled = err
 			}
-			workflow.Tasks = append(workflow.Tasks, task)
+		default:
+			return err
 		}
+	}
+	return canceled
+}
+
+// parseTask reads a task's properties from its markdown file. YAML front
+// matter and the documented "## Command / Dependencies / Priority / Retries /
+// Timeout" sections are parsed natively and always win, with front matter
+// taking precedence over sections. A file that gives a command is parsed
+// offline, with defaults for the properties it leaves out; gopilot is only
+// consulted when there is no command, such as when the task is written as
+// free-form prose.
+func (p *Parser) parseTask(ctx context.Context, taskPath string) (Task, error) {
+	task := Task{
+		ID:           strings.TrimSuffix(filepath.Base(taskPath), ".md"),
+		MarkdownPath: taskPath,
+		Status:       "pending",
+	}
+
+	content, err := os.ReadFile(taskPath)
+	if err != nil {
+		return Task{}, fmt.Errorf("failed to read task file: %w", err)
+	}
 
-		return workflow, nil
+	props, warnings, err := parseTaskProperties(content)
+	if err != nil {
+		return Task{}, err
+	}
+	task.Warnings = warnings
+
+	if props.complete() {
+		props.setDefaults()
+	} else {
+		spec, err := p.extractor.ExtractTask(ctx, taskPath)
+		if err != nil {
+			return Task{}, err
+		}
+		if spec.Version > gopilotcli.TaskSpecVersion {
+			return Task{}, fmt.Errorf("unsupported task spec version %d, expected at most %d", spec.Version, gopilotcli.TaskSpecVersion)
+		}
+		if err := validateSpec(spec); err != nil {
+			return Task{}, fmt.Errorf("invalid task spec from the extractor: %w", err)
+		}
+		if props.Front != nil {
+			task.Warnings = append(task.Warnings, extractionOverrides(*props.Front, spec)...)
+		}
+		task.Command = spec.Command
+		task.Dependencies = spec.Dependencies
+		task.Priority = spec.Priority
+		task.Retries = spec.Retries
+		task.Timeout = spec.Timeout
+		task.Env = spec.Env
+		task.Workdir = spec.Workdir
+	}
+
+	props.apply(&task)
+	return task, nil
+}
+
+// extractionOverrides returns a warning for every value the extractor found
+// that the front matter replaces with a different one
+func extractionOverrides(front taskProperties, spec *gopilotcli.TaskSpec) []string {
+	var warnings []string
+	conflict := func(name, frontValue, extracted string) {
+		if extracted != "" && frontValue != extracted {
+			warnings = append(warnings, fmt.Sprintf("front matter %s %q overrides the extracted %s %q", name, frontValue, name, extracted))
+		}
+	}
+
+	if front.Dependencies != nil {
+		conflict("dependencies", strings.Join(*front.Dependencies, ", "), strings.Join(spec.Dependencies, ", "))
+	}
+	if front.Priority != nil {
+		conflict("priority", *front.Priority, spec.Priority)
+	}
+	if front.Retries != nil && spec.Retries != 0 {
+		conflict("retries", strconv.Itoa(*front.Retries), strconv.Itoa(spec.Retries))
+	}
+	if front.Timeout != nil {
+		conflict("timeout", *front.Timeout, spec.Timeout)
+	}
+	if front.Env != nil {
+		conflict("env", formatEnv(front.Env), formatEnv(spec.Env))
+	}
+	if front.Workdir != nil {
+		conflict("workdir", *front.Workdir, spec.Workdir)
+	}
+	return warnings
+}
+
+// formatEnv renders environment variables as sorted, comma-separated
+// KEY=value pairs
+func formatEnv(env map[string]string) string {
+	pairs := make([]string, 0, len(env))
+	for key, value := range env {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+// validateSpec checks an extracted spec the same way as the properties a
+// task file spells out, normalising its priority. An empty priority or
+// timeout leaves the default in place.
+func validateSpec(spec *gopilotcli.TaskSpec) error {
+	if spec.Priority != "" {
+		priority, err := parsePriority(spec.Priority)
+		if err != nil {
+			return err
+		}
+		spec.Priority = priority
+	}
+	if spec.Retries < 0 {
+		return fmt.Errorf("invalid retries %d, expected a non-negative number", spec.Retries)
+	}
+	if spec.Timeout != "" {
+		timeout, err := parseTimeout(spec.Timeout)
+		if err != nil {
+			return err
+		}
+		spec.Timeout = timeout
+	}
+	return nil
+}
+
+// dependencySuffix marks a symlink or directory as holding a task's upstream
+// dependencies
+const dependencySuffix = "_dependencies"
+
+// dependencySource returns the task whose dependencies are described by the
+// given entry name. Three forms are recognised:
+//
+//	taskB_dependencies       a symlink to a single upstream task, or a
+//	                         directory containing one symlink per upstream task
+//	taskB_dependencies.N     one of several numbered symlinks, one per upstream
+func dependencySource(name string) (string, bool) {
+	base, index, numbered := strings.Cut(name, dependencySuffix+".")
+	if numbered {
+		if base == "" || index == "" || strings.Trim(index, "0123456789") != "" {
+			return "", false
+		}
+		return base, true
+	}
+
+	if !strings.HasSuffix(name, dependencySuffix) || name == dependencySuffix {
+		return "", false
+	}
+	return strings.TrimSuffix(name, dependencySuffix), true
+}
+
+// workspaceRoot returns the absolute path of the workspace root with any
+// symlinks in it resolved
+func workspaceRoot(basePath string) (string, error) {
+	root, err := filepath.Abs(basePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve workspace root: %w", err)
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve workspace root: %w", err)
+	}
+	return root, nil
+}
+
+// resolveDependencyLink returns the canonical ID of the task a dependency
+// symlink points to. Tasks in the same workflow are referred to by their bare
+// ID and tasks in other workflows by their qualified "<workflow>/<task>" ID.
+// The target must be an existing task file inside the workspace root.
+func resolveDependencyLink(root, workflowName, linkPath string) (string, error) {
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read symlink: %w", err)
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(linkPath), target)
+	}
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve symlink: %w", err)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return "", fmt.Errorf("dangling symlink to missing task %s", target)
+	}
+	if info.IsDir() || !strings.HasSuffix(target, ".md") {
+		return "", fmt.Errorf("symlink must point to a task markdown file, got %s", target)
+	}
+
+	targetDir, err := filepath.EvalSymlinks(filepath.Dir(target))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve symlink: %w", err)
+	}
+	rel, err := filepath.Rel(root, targetDir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("symlink points outside the workspace: %s", target)
+	}
+	if rel == "." {
+		return "", fmt.Errorf("symlink points to %s, which is not inside a workflow", target)
+	}
+
+	taskID := strings.TrimSuffix(filepath.Base(target), ".md")
+	targetWorkflow := filepath.ToSlash(rel)
+	if targetWorkflow == workflowName {
+		return taskID, nil
+	}
+	return QualifiedTaskID(targetWorkflow, taskID), nil
+}
+
+// readDependencyDir returns the path of every symlink in a fan-in dependency
+// directory, in directory order
+func readDependencyDir(dirPath string) ([]string, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dependency directory: %w", err)
+	}
+
+	var links []string
+	for _, entry := range entries {
+		if entry.Type()&os.ModeSymlink != 0 {
+			links = append(links, filepath.Join(dirPath, entry.Name()))
+		}
 	}
+	return links, nil
 }
 
 // Helper function to identify project-specific directories
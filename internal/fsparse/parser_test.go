@@ -2,9 +2,17 @@ package fsparse
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/zackiles/task-graph-fs/internal/gopilotcli"
 )
 
 func TestParseWorkflows(t *testing.T) {
@@ -140,3 +148,514 @@ high
 		t.Errorf("expected 1 task, got %d", len(workflow.Tasks))
 	}
 }
+
+func TestParseFanInDependencies(t *testing.T) {
+	testDir := t.TempDir()
+
+	workflowDir := filepath.Join(testDir, "workflow1")
+	if err := os.MkdirAll(workflowDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"fetch", "clean", "enrich", "join", "report"} {
+		content := "# " + name + "\n## Command\necho " + name + "\n"
+		if err := os.WriteFile(filepath.Join(workflowDir, name+".md"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// join depends on clean and enrich through a dependency directory
+	depsDir := filepath.Join(workflowDir, "join_dependencies")
+	if err := os.MkdirAll(depsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, upstream := range []string{"clean", "enrich"} {
+		if err := os.Symlink(filepath.Join("..", upstream+".md"), filepath.Join(depsDir, upstream+".md")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// report depends on fetch and join through numbered symlinks
+	for i, upstream := range []string{"fetch", "join"} {
+		link := filepath.Join(workflowDir, "report_dependencies."+strconv.Itoa(i+1))
+		if err := os.Symlink(upstream+".md", link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parser := NewParserWithGopilot(gopilotcli.NewMockGopilot())
+
+	workflows, err := parser.ParseWorkflows(context.Background(), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The dependency directory must not be mistaken for a nested workflow
+	if len(workflows) != 1 {
+		t.Fatalf("expected 1 workflow, got %d", len(workflows))
+	}
+
+	workflow := workflows[0]
+	if len(workflow.Tasks) != 5 {
+		t.Errorf("expected 5 tasks, got %d", len(workflow.Tasks))
+	}
+
+	expected := map[string][]string{
+		"join":   {"clean", "enrich"},
+		"report": {"fetch", "join"},
+	}
+	for task, want := range expected {
+		got := workflow.TaskDependencies(task)
+		if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("expected %s to depend on %v, got %v", task, want, got)
+		}
+	}
+}
+
+func TestParseCrossWorkflowDependencies(t *testing.T) {
+	testDir := t.TempDir()
+
+	for _, dir := range []string{"data-pipeline", "model-training"} {
+		if err := os.MkdirAll(filepath.Join(testDir, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, task := range []string{"data-pipeline/transform-data", "model-training/prepare-features", "model-training/train-model"} {
+		content := "# " + task + "\n## Command\necho " + task + "\n"
+		if err := os.WriteFile(filepath.Join(testDir, task+".md"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trainingDir := filepath.Join(testDir, "model-training")
+	if err := os.Symlink("../data-pipeline/transform-data.md", filepath.Join(trainingDir, "prepare-features_dependencies")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("prepare-features.md", filepath.Join(trainingDir, "train-model_dependencies")); err != nil {
+		t.Fatal(err)
+	}
+
+	parser := NewParserWithGopilot(gopilotcli.NewMockGopilot())
+
+	workflows, err := parser.ParseWorkflows(context.Background(), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workflows) != 2 {
+		t.Fatalf("expected 2 workflows, got %d", len(workflows))
+	}
+
+	var training Workflow
+	for _, w := range workflows {
+		if w.Name == "model-training" {
+			training = w
+		}
+	}
+
+	if deps := training.Dependencies["prepare-features"]; len(deps) != 1 || deps[0] != "data-pipeline/transform-data" {
+		t.Errorf("expected prepare-features to depend on 'data-pipeline/transform-data', got %v", deps)
+	}
+	if deps := training.Dependencies["train-model"]; len(deps) != 1 || deps[0] != "prepare-features" {
+		t.Errorf("expected train-model to depend on 'prepare-features', got %v", deps)
+	}
+}
+
+func TestParseInvalidDependencyTargets(t *testing.T) {
+	tests := map[string]string{
+		"missing task":      "does-not-exist.md",
+		"outside workspace": "",
+	}
+
+	for name, target := range tests {
+		t.Run(name, func(t *testing.T) {
+			testDir := t.TempDir()
+			workflowDir := filepath.Join(testDir, "workflow1")
+			if err := os.MkdirAll(workflowDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(workflowDir, "taskA.md"), []byte("# taskA\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if target == "" {
+				// A task file that lives outside the workspace root
+				target = filepath.Join(t.TempDir(), "outside.md")
+				if err := os.WriteFile(target, []byte("# outside\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Symlink(target, filepath.Join(workflowDir, "taskA_dependencies")); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := NewParserWithGopilot(gopilotcli.NewMockGopilot()).ParseWorkflows(context.Background(), testDir); err == nil {
+				t.Errorf("expected an error for a dependency on a %s", name)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	testDir := t.TempDir()
+
+	workflowDir := filepath.Join(testDir, "workflow1")
+	if err := os.MkdirAll(workflowDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"taskA", "taskB", "taskC", "taskD"} {
+		if err := os.WriteFile(filepath.Join(workflowDir, name+".md"), []byte("# "+name+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		"taskA_dependencies": "taskB.md",   // taskA <- taskB
+		"taskB_dependencies": "taskA.md",   // taskB <- taskA closes a cycle
+		"taskC_dependencies": "taskC.md",   // self dependency
+		"taskD_dependencies": "missing.md", // dangling symlink
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(workflowDir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := NewParserWithGopilot(gopilotcli.NewMockGopilot()).Validate(context.Background(), testDir)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if len(validationErr.Problems) != 3 {
+		t.Fatalf("expected 3 problems, got %d: %v", len(validationErr.Problems), validationErr.Problems)
+	}
+
+	expected := map[string]string{
+		filepath.Join(workflowDir, "taskA.md"):           "dependency cycle: workflow1/taskA -> workflow1/taskB -> workflow1/taskA",
+		filepath.Join(workflowDir, "taskC_dependencies"): "task taskC depends on itself",
+		filepath.Join(workflowDir, "taskD_dependencies"): "dangling symlink",
+	}
+	for _, p := range validationErr.Problems {
+		want, ok := expected[p.Path]
+		if !ok {
+			t.Errorf("unexpected problem %s", p)
+			continue
+		}
+		if !strings.Contains(p.Message, want) {
+			t.Errorf("expected problem for %s to contain %q, got %q", p.Path, want, p.Message)
+		}
+	}
+}
+
+func TestValidateUnknownDependency(t *testing.T) {
+	workflows := []Workflow{
+		{
+			Name: "workflow1",
+			Tasks: []Task{
+				{ID: "taskA", MarkdownPath: "workflow1/taskA.md", Dependencies: []string{"other/taskZ"}},
+			},
+		},
+	}
+
+	problems := ValidateGraph(workflows)
+	if len(problems) != 1 || !strings.Contains(problems[0].Message, "unknown task other/taskZ") {
+		t.Errorf("expected an unknown dependency problem, got %v", problems)
+	}
+}
+
+func TestParseStructuredTaskWithoutGopilot(t *testing.T) {
+	testDir := t.TempDir()
+
+	workflowDir := filepath.Join(testDir, "workflow1")
+	if err := os.MkdirAll(workflowDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	taskA := "# TaskA\n## Command\necho a\n## Dependencies\nNone\n## Priority\nlow\n## Retries\n0\n## Timeout\n1m\n"
+	taskB := "# TaskB\n\n## Command\n```sh\n# fetch then build\n./fetch.sh && make build\n```\n\n" +
+		"## Dependencies\n- `TaskA.md`\n\n## Priority\nHigh\n\n## Retries\n3\n\n## Timeout\n2h\n"
+	// Only the command is required, the rest take their defaults
+	taskC := "# TaskC\n\nRun the tests.\n\n## Command\nmake test\n"
+	for name, content := range map[string]string{"taskA.md": taskA, "taskB.md": taskB, "taskC.md": taskC} {
+		if err := os.WriteFile(filepath.Join(workflowDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Any call to gopilot fails the parse
+	mock := gopilotcli.NewMockGopilot()
+	for _, name := range []string{"taskA.md", "taskB.md", "taskC.md"} {
+		mock.SetResponse(filepath.Join(workflowDir, name), gopilotcli.TaskResponse{Error: errors.New("gopilot called")})
+	}
+
+	workflows, err := NewParserWithGopilot(mock).ParseWorkflows(context.Background(), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := workflows[0].Tasks[1]
+	if task.Command != "# fetch then build\n./fetch.sh && make build" {
+		t.Errorf("unexpected command %q", task.Command)
+	}
+	if len(task.Dependencies) != 1 || task.Dependencies[0] != "taskA" {
+		t.Errorf("expected dependency on taskA, got %v", task.Dependencies)
+	}
+	if task.Priority != "high" || task.Retries != 3 || task.Timeout != "2h" {
+		t.Errorf("unexpected properties: priority=%s retries=%d timeout=%s", task.Priority, task.Retries, task.Timeout)
+	}
+
+	task = workflows[0].Tasks[2]
+	if task.Command != "make test" || len(task.Dependencies) != 0 {
+		t.Errorf("unexpected command %q or dependencies %v", task.Command, task.Dependencies)
+	}
+	if task.Priority != "medium" || task.Retries != 0 || task.Timeout != "" {
+		t.Errorf("expected default properties, got priority=%s retries=%d timeout=%q", task.Priority, task.Retries, task.Timeout)
+	}
+}
+
+func TestParseStructuredTaskInvalidValues(t *testing.T) {
+	tests := map[string]string{
+		"priority": "## Command\necho a\n## Priority\nurgent\n",
+		"retries":  "## Command\necho a\n## Retries\nthree\n",
+		"timeout":  "## Command\necho a\n## Timeout\nforever\n",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseTaskSections([]byte(content)); err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("expected an invalid %s error, got %v", name, err)
+			}
+		})
+	}
+}
+
+func TestParseFrontMatter(t *testing.T) {
+	testDir := t.TempDir()
+
+	workflowDir := filepath.Join(testDir, "workflow1")
+	if err := os.MkdirAll(workflowDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	taskA := "---\ncommand: echo a\ndependencies: []\npriority: low\nretries: 0\ntimeout: 1m\n---\n# TaskA\n"
+	taskB := "---\ncommand: make build\ndependencies: [taskA]\npriority: High\nretries: 2\ntimeout: 10m\n" +
+		"env:\n  GOOS: linux\nworkdir: src\n---\n# TaskB\n\n## Command\nmake all\n\n## Retries\n2\n"
+	for name, content := range map[string]string{"taskA.md": taskA, "taskB.md": taskB} {
+		if err := os.WriteFile(filepath.Join(workflowDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Any call to gopilot fails the parse
+	mock := gopilotcli.NewMockGopilot()
+	for _, name := range []string{"taskA.md", "taskB.md"} {
+		mock.SetResponse(filepath.Join(workflowDir, name), gopilotcli.TaskResponse{Error: errors.New("gopilot called")})
+	}
+
+	workflows, err := NewParserWithGopilot(mock).ParseWorkflows(context.Background(), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := workflows[0].Tasks[1]
+	if task.Command != "make build" {
+		t.Errorf("expected front matter command to win, got %q", task.Command)
+	}
+	if len(task.Dependencies) != 1 || task.Dependencies[0] != "taskA" {
+		t.Errorf("expected dependency on taskA, got %v", task.Dependencies)
+	}
+	if task.Priority != "high" || task.Retries != 2 || task.Timeout != "10m" {
+		t.Errorf("unexpected properties: priority=%s retries=%d timeout=%s", task.Priority, task.Retries, task.Timeout)
+	}
+	if task.Env["GOOS"] != "linux" || task.Workdir != "src" {
+		t.Errorf("unexpected env %v or workdir %q", task.Env, task.Workdir)
+	}
+
+	// Only the conflicting command is reported, not the matching retries
+	if len(task.Warnings) != 1 || !strings.Contains(task.Warnings[0], "make all") {
+		t.Errorf("expected a single command conflict warning, got %v", task.Warnings)
+	}
+}
+
+func TestParseFrontMatterOverridesExtraction(t *testing.T) {
+	testDir := t.TempDir()
+
+	workflowDir := filepath.Join(testDir, "workflow1")
+	if err := os.MkdirAll(workflowDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	taskPath := filepath.Join(workflowDir, "build.md")
+	content := "---\npriority: low\nretries: 1\ntimeout: 10m\n---\n# Build\n\nBuild the project, it's urgent.\n"
+	if err := os.WriteFile(taskPath, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mock := gopilotcli.NewMockGopilot()
+	mock.SetResponse(taskPath, gopilotcli.TaskResponse{
+		Command:  "make build",
+		Priority: "high",
+		Timeout:  "10m",
+	})
+
+	workflows, err := NewParserWithExtractor(mock).ParseWorkflows(context.Background(), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := workflows[0].Tasks[0]
+	if task.Command != "make build" || task.Priority != "low" || task.Retries != 1 || task.Timeout != "10m" {
+		t.Errorf("expected front matter to win over the extracted spec, got %+v", task)
+	}
+
+	// Only the priority differs from a value the extractor found
+	if len(task.Warnings) != 1 || task.Warnings[0] != `front matter priority "low" overrides the extracted priority "high"` {
+		t.Errorf("expected a single priority warning, got %v", task.Warnings)
+	}
+}
+
+func TestParseFrontMatterInvalidValues(t *testing.T) {
+	tests := map[string]string{
+		"priority":     "---\npriority: urgent\n---\n",
+		"retries":      "---\nretries: -1\n---\n",
+		"timeout":      "---\ntimeout: forever\n---\n",
+		"front matter": "---\ncommand: [unterminated\n---\n",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := parseTaskProperties([]byte(content)); err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("expected an invalid %s error, got %v", name, err)
+			}
+		})
+	}
+}
+
+func TestParseTaskSpec(t *testing.T) {
+	testDir := t.TempDir()
+
+	workflowDir := filepath.Join(testDir, "workflow1")
+	if err := os.MkdirAll(workflowDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	taskPath := filepath.Join(workflowDir, "build.md")
+	if err := os.WriteFile(taskPath, []byte("# Build\n\nBuild the project for linux.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mock := gopilotcli.NewMockGopilot()
+	mock.SetResponse(taskPath, gopilotcli.TaskResponse{Spec: &gopilotcli.TaskSpec{
+		Version:  gopilotcli.TaskSpecVersion,
+		Command:  "make build",
+		Priority: "high",
+		Timeout:  "10m",
+		Env:      map[string]string{"GOOS": "linux"},
+		Workdir:  "src",
+	}})
+
+	workflows, err := NewParserWithExtractor(mock).ParseWorkflows(context.Background(), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := workflows[0].Tasks[0]
+	if task.Command != "make build" || task.Env["GOOS"] != "linux" || task.Workdir != "src" {
+		t.Errorf("expected task spec to be applied, got %+v", task)
+	}
+
+	// Malformed values are rejected, naming the task file
+	for field, spec := range map[string]gopilotcli.TaskSpec{
+		"priority": {Version: gopilotcli.TaskSpecVersion, Command: "make build", Priority: "urgent"},
+		"retries":  {Version: gopilotcli.TaskSpecVersion, Command: "make build", Retries: -1},
+		"timeout":  {Version: gopilotcli.TaskSpecVersion, Command: "make build", Timeout: "forever"},
+	} {
+		mock.SetResponse(taskPath, gopilotcli.TaskResponse{Spec: &spec})
+		_, err := NewParserWithExtractor(mock).ParseWorkflows(context.Background(), testDir)
+		if err == nil || !strings.Contains(err.Error(), "build.md") || !strings.Contains(err.Error(), "invalid "+field) {
+			t.Errorf("expected an invalid %s error naming build.md, got %v", field, err)
+		}
+	}
+
+	// Specs from a newer schema are rejected rather than misread
+	mock.SetResponse(taskPath, gopilotcli.TaskResponse{Spec: &gopilotcli.TaskSpec{Version: gopilotcli.TaskSpecVersion + 1}})
+	if _, err := NewParserWithExtractor(mock).ParseWorkflows(context.Background(), testDir); err == nil || !strings.Contains(err.Error(), "unsupported task spec version") {
+		t.Errorf("expected an unsupported version error, got %v", err)
+	}
+}
+
+// slowExtractor records how many extractions run at once
+type slowExtractor struct {
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func (s *slowExtractor) ExtractTask(ctx context.Context, taskPath string) (*gopilotcli.TaskSpec, error) {
+	s.mu.Lock()
+	s.running++
+	if s.running > s.peak {
+		s.peak = s.running
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.running--
+		s.mu.Unlock()
+	}()
+
+	select {
+	case <-time.After(20 * time.Millisecond):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &gopilotcli.TaskSpec{Version: gopilotcli.TaskSpecVersion, Command: "echo " + filepath.Base(taskPath)}, nil
+}
+
+func TestParseWorkflowsParallelism(t *testing.T) {
+	testDir := t.TempDir()
+
+	for _, workflow := range []string{"workflow1", "workflow2"} {
+		workflowDir := filepath.Join(testDir, workflow)
+		if err := os.MkdirAll(workflowDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 4; i++ {
+			content := fmt.Sprintf("# task%d\n\nDo step %d.\n", i, i)
+			if err := os.WriteFile(filepath.Join(workflowDir, fmt.Sprintf("task%d.md", i)), []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	extractor := &slowExtractor{}
+	parser := NewParserWithExtractor(extractor)
+	parser.SetParallelism(3)
+
+	workflows, err := parser.ParseWorkflows(context.Background(), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if extractor.peak > 3 {
+		t.Errorf("expected at most 3 concurrent extractions, got %d", extractor.peak)
+	}
+
+	// Results come back in directory order regardless of completion order
+	if len(workflows) != 2 || workflows[0].Name != "workflow1" || workflows[1].Name != "workflow2" {
+		t.Fatalf("unexpected workflows %+v", workflows)
+	}
+	for _, w := range workflows {
+		for i, task := range w.Tasks {
+			if want := fmt.Sprintf("task%d", i); task.ID != want || task.Command != "echo "+want+".md" {
+				t.Errorf("expected %s at position %d, got %s (%q)", want, i, task.ID, task.Command)
+			}
+		}
+	}
+
+	// A cancelled context stops the parse
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := parser.ParseWorkflows(ctx, testDir); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancellation error, got %v", err)
+	}
+}
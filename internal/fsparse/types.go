@@ -1,11 +1,123 @@
 package fsparse
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
 type Workflow struct {
 	Name         string
+	Path         string
 	Tasks        []Task
 	Dependencies map[string][]string
 }
 
+// QualifiedTaskID returns the workspace-wide ID of a task, "<workflow>/<task>"
+func QualifiedTaskID(workflowName, taskID string) string {
+	return workflowName + "/" + taskID
+}
+
+// SplitTaskID splits a qualified task ID into its workflow name and task ID.
+// Workflow names may contain slashes, so the task is the last path element.
+// A bare task ID returns an empty workflow name.
+func SplitTaskID(id string) (workflowName, taskID string) {
+	idx := strings.LastIndex(id, "/")
+	if idx < 0 {
+		return "", id
+	}
+	return id[:idx], id[idx+1:]
+}
+
+// TaskDependencies returns the sorted, de-duplicated set of upstream task IDs
+// for the given task, combining symlink edges with the task's own declared
+// dependencies. A task without dependencies gets an empty, non-nil slice so
+// that it is written as [] rather than null.
+func (w Workflow) TaskDependencies(taskID string) []string {
+	seen := make(map[string]bool)
+	deps := []string{}
+	add := func(ids []string) {
+		for _, id := range ids {
+			if id == "" || seen[id] {
+				continue
+			}
+			seen[id] = true
+			deps = append(deps, id)
+		}
+	}
+
+	add(w.Dependencies[taskID])
+	for _, task := range w.Tasks {
+		if task.ID == taskID {
+			add(task.Dependencies)
+			break
+		}
+	}
+
+	sort.Strings(deps)
+	return deps
+}
+
+// canonicalizeDependencies rewrites dependencies declared inside task files to
+// the exact IDs of the tasks they name, so "TaskA" or "taska" resolve to taskA
+func (w *Workflow) canonicalizeDependencies() {
+	byFold := make(map[string]string, len(w.Tasks))
+	for _, task := range w.Tasks {
+		byFold[strings.ToLower(task.ID)] = task.ID
+	}
+
+	for i := range w.Tasks {
+		for j, dep := range w.Tasks[i].Dependencies {
+			if id, ok := byFold[strings.ToLower(dep)]; ok {
+				w.Tasks[i].Dependencies[j] = id
+			}
+		}
+	}
+}
+
+func (w Workflow) hasTask(taskID string) bool {
+	for _, task := range w.Tasks {
+		if task.ID == taskID {
+			return true
+		}
+	}
+	return false
+}
+
+// TaskHash returns a hash of everything that defines how the given task runs.
+// A task whose hash is unchanged since it last completed doesn't need to run
+// again.
+func (w Workflow) TaskHash(taskID string) string {
+	for _, task := range w.Tasks {
+		if task.ID != taskID {
+			continue
+		}
+
+		definition, _ := json.Marshal(struct {
+			Command      string
+			Dependencies []string
+			Priority     string
+			Retries      int
+			Timeout      string
+			Env          map[string]string `json:",omitempty"`
+			Workdir      string            `json:",omitempty"`
+		}{
+			Command:      task.Command,
+			Dependencies: w.TaskDependencies(taskID),
+			Priority:     task.Priority,
+			Retries:      task.Retries,
+			Timeout:      task.Timeout,
+			Env:          task.Env,
+			Workdir:      task.Workdir,
+		})
+		sum := sha256.Sum256(definition)
+		return hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
 type Task struct {
 	ID           string
 	MarkdownPath string
@@ -14,7 +126,12 @@ type Task struct {
 	Priority     string
 	Retries      int
 	Timeout      string
+	Env          map[string]string
+	Workdir      string
 	Status       string
 	Output       string
 	Duration     string
+	// Warnings are conflicts found while parsing the task file, such as front
+	// matter overriding a heading section
+	Warnings []string
 }
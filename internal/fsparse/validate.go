@@ -0,0 +1,164 @@
+package fsparse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Problem describes something wrong with the workflow graph, pointing at the
+// file that causes it
+type Problem struct {
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// ValidationError reports every problem found while validating workflows
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = "  " + p.String()
+	}
+	return fmt.Sprintf("found %d problem(s) in the workflow graph:\n%s", len(e.Problems), strings.Join(lines, "\n"))
+}
+
+// ValidateGraph checks parsed workflows for duplicate task IDs, self
+// dependencies, dependencies on tasks that don't exist and dependency cycles
+// (within and across workflows).
+func ValidateGraph(workflows []Workflow) []Problem {
+	var problems []Problem
+
+	known := make(map[string]bool)
+	paths := make(map[string]string)
+	for _, w := range workflows {
+		byFold := make(map[string]Task)
+		for _, t := range w.Tasks {
+			id := QualifiedTaskID(w.Name, t.ID)
+			if other, ok := byFold[strings.ToLower(t.ID)]; ok || known[id] {
+				problems = append(problems, Problem{
+					Path:    t.MarkdownPath,
+					Message: fmt.Sprintf("duplicate task ID %s (also defined by %s)", id, other.MarkdownPath),
+				})
+				continue
+			}
+			byFold[strings.ToLower(t.ID)] = t
+			known[id] = true
+			paths[id] = t.MarkdownPath
+		}
+	}
+
+	// Build the workspace-wide graph on qualified IDs
+	graph := make(map[string][]string)
+	for _, w := range workflows {
+		for _, t := range w.Tasks {
+			id := QualifiedTaskID(w.Name, t.ID)
+			for _, dep := range w.TaskDependencies(t.ID) {
+				depID := dep
+				if depWorkflow, _ := SplitTaskID(dep); depWorkflow == "" {
+					depID = QualifiedTaskID(w.Name, dep)
+				}
+
+				switch {
+				case depID == id:
+					problems = append(problems, Problem{Path: t.MarkdownPath, Message: fmt.Sprintf("task %s depends on itself", t.ID)})
+				case !known[depID]:
+					problems = append(problems, Problem{Path: t.MarkdownPath, Message: fmt.Sprintf("task %s depends on unknown task %s", t.ID, dep)})
+				default:
+					graph[id] = append(graph[id], depID)
+				}
+			}
+		}
+	}
+
+	for _, cycle := range findCycles(graph) {
+		problems = append(problems, Problem{
+			Path:    paths[cycle[0]],
+			Message: "dependency cycle: " + strings.Join(cycle, " -> "),
+		})
+	}
+
+	sortProblems(problems)
+	return problems
+}
+
+// findCycles returns each dependency cycle in the graph once, as the path of
+// task IDs starting and ending with the same task
+func findCycles(graph map[string][]string) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	nodes := make([]string, 0, len(graph))
+	for id := range graph {
+		nodes = append(nodes, id)
+	}
+	sort.Strings(nodes)
+
+	color := make(map[string]int)
+	var stack []string
+	var cycles [][]string
+
+	var visit func(id string)
+	visit = func(id string) {
+		color[id] = visiting
+		stack = append(stack, id)
+
+		for _, dep := range graph[id] {
+			switch color[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				// dep is on the stack: everything from it onwards is a cycle.
+				// The graph points downstream to upstream, so reverse it to
+				// read in execution order.
+				start := len(stack) - 1
+				for stack[start] != dep {
+					start--
+				}
+				cycle := make([]string, 0, len(stack)-start+1)
+				for i := len(stack) - 1; i >= start; i-- {
+					cycle = append(cycle, stack[i])
+				}
+
+				// Start from the smallest ID so the output is stable
+				first := 0
+				for i := range cycle {
+					if cycle[i] < cycle[first] {
+						first = i
+					}
+				}
+				rotated := make([]string, 0, len(cycle)+1)
+				rotated = append(rotated, cycle[first:]...)
+				rotated = append(rotated, cycle[:first]...)
+				cycles = append(cycles, append(rotated, rotated[0]))
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		color[id] = visited
+	}
+
+	for _, id := range nodes {
+		if color[id] == unvisited {
+			visit(id)
+		}
+	}
+
+	return cycles
+}
+
+func sortProblems(problems []Problem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Path < problems[j].Path
+	})
+}
@@ -0,0 +1,80 @@
+package gopilotcli
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zackiles/task-graph-fs/internal/fsutil"
+)
+
+// Cacheable is implemented by extractors whose output depends only on the
+// task file's content and on the identity CacheKey returns, such as the
+// provider, model and prompt version. Only cacheable extractors are cached.
+type Cacheable interface {
+	CacheKey() string
+}
+
+// cachingExtractor stores extracted specs on disk, keyed by a hash of the
+// extractor's identity and the task file's content
+type cachingExtractor struct {
+	extractor Extractor
+	identity  string
+	dir       string
+}
+
+// NewCachingExtractor wraps e so that specs are read from and written to the
+// cache directory. Extractors that don't implement Cacheable are returned
+// unchanged.
+func NewCachingExtractor(e Extractor, dir string) Extractor {
+	c, ok := e.(Cacheable)
+	if !ok {
+		return e
+	}
+	return &cachingExtractor{
+		extractor: e,
+		identity:  c.CacheKey(),
+		dir:       dir,
+	}
+}
+
+func (c *cachingExtractor) ExtractTask(ctx context.Context, taskPath string) (*TaskSpec, error) {
+	content, err := os.ReadFile(taskPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task file: %w", err)
+	}
+
+	entryPath := c.entryPath(content)
+	if data, err := os.ReadFile(entryPath); err == nil {
+		var spec TaskSpec
+		if json.Unmarshal(data, &spec) == nil && spec.Version == TaskSpecVersion {
+			return &spec, nil
+		}
+	}
+
+	spec, err := c.extractor.ExtractTask(ctx, taskPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cache is best effort; failing to write it doesn't fail the parse
+	if data, err := json.Marshal(spec); err == nil {
+		if os.MkdirAll(filepath.Dir(entryPath), 0o755) == nil {
+			fsutil.WriteFileAtomic(entryPath, data, 0o644)
+		}
+	}
+	return spec, nil
+}
+
+// entryPath returns where the spec for the given content is cached
+func (c *cachingExtractor) entryPath(content []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d\n", c.identity, TaskSpecVersion)
+	h.Write(content)
+	key := hex.EncodeToString(h.Sum(nil))
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
@@ -0,0 +1,74 @@
+package gopilotcli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countingExtractor is a cacheable extractor that counts its calls
+type countingExtractor struct {
+	identity string
+	calls    int
+}
+
+func (c *countingExtractor) CacheKey() string {
+	return c.identity
+}
+
+func (c *countingExtractor) ExtractTask(ctx context.Context, taskPath string) (*TaskSpec, error) {
+	c.calls++
+	return &TaskSpec{Version: TaskSpecVersion, Command: "echo extracted", Dependencies: []string{}}, nil
+}
+
+func TestCachingExtractor(t *testing.T) {
+	cacheDir := t.TempDir()
+	taskPath := filepath.Join(t.TempDir(), "build.md")
+	if err := os.WriteFile(taskPath, []byte("# Build\n\nBuild it.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	inner := &countingExtractor{identity: "model-a"}
+	cached := NewCachingExtractor(inner, cacheDir)
+
+	for i := 0; i < 2; i++ {
+		spec, err := cached.ExtractTask(context.Background(), taskPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec.Command != "echo extracted" {
+			t.Errorf("unexpected command %q", spec.Command)
+		}
+	}
+	if inner.calls != 1 {
+		t.Errorf("expected one extraction for unchanged content, got %d", inner.calls)
+	}
+
+	// Changing the task file misses the cache
+	if err := os.WriteFile(taskPath, []byte("# Build\n\nBuild it twice.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cached.ExtractTask(context.Background(), taskPath); err != nil {
+		t.Fatal(err)
+	}
+	if inner.calls != 2 {
+		t.Errorf("expected changed content to be extracted again, got %d calls", inner.calls)
+	}
+
+	// So does a different provider or model
+	other := &countingExtractor{identity: "model-b"}
+	if _, err := NewCachingExtractor(other, cacheDir).ExtractTask(context.Background(), taskPath); err != nil {
+		t.Fatal(err)
+	}
+	if other.calls != 1 {
+		t.Errorf("expected a different identity to miss the cache, got %d calls", other.calls)
+	}
+}
+
+func TestCachingExtractorSkipsUncacheable(t *testing.T) {
+	mock := NewMockGopilot()
+	if NewCachingExtractor(mock, t.TempDir()) != Extractor(mock) {
+		t.Error("expected an extractor without a cache key to be left uncached")
+	}
+}
@@ -1,36 +1,151 @@
 package gopilotcli
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
 	"os/exec"
+	"strings"
 )
 
-// NOTE: This is not a real Gopilot integration
-// Gopilot is still being developed and can be found here https://github.com/zackiles/gopilot
-// For now, we're just mocking the expected interface and behavior
-type RealGopilot struct{}
+// The gopilot subprocess contract. TaskGraphFS runs
+//
+//	gopilot parse --path <task.md> --output json
+//
+// and expects a single JSON object on stdout:
+//
+//	{"schema_version": 1, "task": {"command": "...", "dependencies": [...], ...}}
+//
+// where "task" follows the TaskSpec JSON schema and "schema_version" is the
+// TaskSpec version it was written for. Human-readable diagnostics go to stderr.
+// Exit codes:
+//
+//	0  the task was extracted and stdout holds the response
+//	2  the task file couldn't be understood; stderr says why
+//	*  any other non-zero code is an internal gopilot failure
+const (
+	// ExitInvalidTask is the exit code gopilot uses when it can't extract a
+	// task from the given file
+	ExitInvalidTask = 2
 
+	// PathEnv overrides the gopilot binary run by NewRealGopilot
+	PathEnv = "TGFS_GOPILOT"
+
+	defaultBinary = "gopilot"
+)
+
+// maxDiagnostics bounds how much of gopilot's stderr is quoted in errors
+const maxDiagnostics = 1024
+
+// Response is the JSON object gopilot writes to stdout
+type Response struct {
+	SchemaVersion int       `json:"schema_version"`
+	Task          *TaskSpec `json:"task"`
+}
+
+// RealGopilot extracts tasks by running the gopilot binary
+type RealGopilot struct {
+	// Path is the gopilot binary to run, looked up in PATH when it has no
+	// directory component
+	Path string
+}
+
+// NewRealGopilot creates a RealGopilot that runs the binary named by
+// $TGFS_GOPILOT, or "gopilot" from PATH
 func NewRealGopilot() *RealGopilot {
-	return &RealGopilot{}
+	path := os.Getenv(PathEnv)
+	if path == "" {
+		path = defaultBinary
+	}
+	return NewRealGopilotWithPath(path)
+}
+
+// NewRealGopilotWithPath creates a RealGopilot that runs the given binary
+func NewRealGopilotWithPath(path string) *RealGopilot {
+	return &RealGopilot{Path: path}
+}
+
+// CacheKey identifies the gopilot binary whose output is being cached. It
+// includes the size and modification time of the binary the path resolves
+// to, so that upgrading gopilot in place invalidates what it extracted.
+func (g *RealGopilot) CacheKey() string {
+	key := "gopilot:" + g.Path
+	resolved, err := exec.LookPath(g.Path)
+	if err != nil {
+		// Extraction will fail and nothing gets cached
+		return key
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return key
+	}
+	return fmt.Sprintf("%s:%s:%d:%d", key, resolved, info.Size(), info.ModTime().UnixNano())
 }
 
 func (g *RealGopilot) GenerateTaskProps(ctx context.Context, taskPath string) (string, []string, string, int, string, error) {
-	// Create command with context
-	cmd := exec.CommandContext(ctx, "gopilot", "parse", "--path", taskPath)
+	return generateTaskProps(ctx, g, taskPath)
+}
+
+func (g *RealGopilot) ExtractTask(ctx context.Context, taskPath string) (*TaskSpec, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, g.Path, "parse", "--path", taskPath, "--output", "json")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
 
-	// Run command with context awareness
 	if err := cmd.Run(); err != nil {
-		// For now, since gopilot isn't implemented, return mock values instead of error
-		// This matches the example task format in /internal/integration/mock-workflow1/task.example.md
-		return "python example_script.py", []string{}, "medium", 1, "30m", nil
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("failed to run gopilot %q: %w", g.Path, err)
+		}
+
+		diagnostics := diagnosticsFrom(stderr.String())
+		if exitErr.ExitCode() == ExitInvalidTask {
+			return nil, fmt.Errorf("gopilot could not extract a task from %s: %s", taskPath, diagnostics)
+		}
+		return nil, fmt.Errorf("gopilot exited with code %d: %s", exitErr.ExitCode(), diagnostics)
+	}
+
+	return decodeResponse(stdout.Bytes())
+}
+
+// decodeResponse parses gopilot's stdout into a TaskSpec
+func decodeResponse(data []byte) (*TaskSpec, error) {
+	var resp Response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("invalid gopilot output: %w", err)
 	}
 
-	// Check if context was cancelled
-	select {
-	case <-ctx.Done():
-		return "", nil, "", 0, "", ctx.Err()
-	default:
-		// Return values that match the example task format
-		return "python example_script.py", []string{}, "medium", 1, "30m", nil
+	if resp.SchemaVersion < 1 || resp.SchemaVersion > TaskSpecVersion {
+		return nil, fmt.Errorf("unsupported gopilot schema version %d, expected 1 to %d", resp.SchemaVersion, TaskSpecVersion)
+	}
+	if resp.Task == nil {
+		return nil, fmt.Errorf("invalid gopilot output: missing task")
+	}
+
+	spec := resp.Task
+	spec.Version = resp.SchemaVersion
+	if spec.Dependencies == nil {
+		spec.Dependencies = []string{}
+	}
+	return spec, nil
+}
+
+// diagnosticsFrom trims gopilot's stderr down to something that fits in an
+// error message
+func diagnosticsFrom(stderr string) string {
+	stderr = strings.TrimSpace(stderr)
+	if stderr == "" {
+		return "no diagnostics on stderr"
+	}
+	if len(stderr) > maxDiagnostics {
+		stderr = "..." + stderr[len(stderr)-maxDiagnostics:]
 	}
+	return stderr
 }
@@ -0,0 +1,107 @@
+package gopilotcli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGopilot writes a shell script standing in for the gopilot binary
+func fakeGopilot(t *testing.T, script string) *RealGopilot {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "gopilot")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return NewRealGopilotWithPath(path)
+}
+
+func TestRealGopilotExtractTask(t *testing.T) {
+	g := fakeGopilot(t, `
+if [ "$1 $2 $3 $4 $5" != "parse --path task.md --output json" ]; then
+	echo "unexpected arguments: $*" >&2
+	exit 1
+fi
+cat <<'JSON'
+{"schema_version": 1, "task": {"command": "make build", "dependencies": ["fetch"], "priority": "high", "retries": 2, "timeout": "10m", "env": {"GOOS": "linux"}, "confidence": 0.9}}
+JSON
+`)
+
+	spec, err := g.ExtractTask(context.Background(), "task.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Version != 1 || spec.Command != "make build" || spec.Priority != "high" || spec.Retries != 2 || spec.Timeout != "10m" {
+		t.Errorf("unexpected spec %+v", spec)
+	}
+	if len(spec.Dependencies) != 1 || spec.Dependencies[0] != "fetch" || spec.Env["GOOS"] != "linux" || spec.Confidence != 0.9 {
+		t.Errorf("unexpected spec %+v", spec)
+	}
+}
+
+func TestRealGopilotErrors(t *testing.T) {
+	tests := map[string]struct {
+		script string
+		want   string
+	}{
+		"invalid task": {
+			script: "echo 'no command found in task' >&2\nexit 2\n",
+			want:   "could not extract a task from task.md: no command found in task",
+		},
+		"internal failure": {
+			script: "echo 'model unavailable' >&2\nexit 3\n",
+			want:   "gopilot exited with code 3: model unavailable",
+		},
+		"invalid output": {
+			script: "echo 'not json'\n",
+			want:   "invalid gopilot output",
+		},
+		"missing task": {
+			script: `echo '{"schema_version": 1}'` + "\n",
+			want:   "missing task",
+		},
+		"unsupported schema": {
+			script: `echo '{"schema_version": 99, "task": {}}'` + "\n",
+			want:   "unsupported gopilot schema version 99",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := fakeGopilot(t, tt.script).ExtractTask(context.Background(), "task.md")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestRealGopilotCacheKey(t *testing.T) {
+	g := fakeGopilot(t, "echo v1\n")
+	before := g.CacheKey()
+	if before != g.CacheKey() {
+		t.Error("expected the cache key to be stable for the same binary")
+	}
+
+	// Upgrading the binary in place changes the key
+	if err := os.WriteFile(g.Path, []byte("#!/bin/sh\necho v2\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(g.Path, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if g.CacheKey() == before {
+		t.Error("expected replacing the binary to change the cache key")
+	}
+}
+
+func TestRealGopilotMissingBinary(t *testing.T) {
+	g := NewRealGopilotWithPath(filepath.Join(t.TempDir(), "gopilot"))
+	if _, err := g.ExtractTask(context.Background(), "task.md"); err == nil || !strings.Contains(err.Error(), "failed to run gopilot") {
+		t.Errorf("expected a failure to run gopilot, got %v", err)
+	}
+}
@@ -11,6 +11,10 @@ import (
 // NOTE: This is not a real Gopilot integration
 // Gopilot is still being developed and can be found here https://github.com/zackiles/gopilot
 // For now, we're just mocking the expected interface and behavior
+//
+// GopilotCLI is the original task extraction interface. New implementations
+// should implement Extractor, which returns a TaskSpec; use NewExtractor to
+// accept either.
 type GopilotCLI interface {
 	GenerateTaskProps(ctx context.Context, taskPath string) (command string, dependencies []string, priority string, retries int, timeout string, err error)
 }
@@ -26,7 +30,25 @@ type TaskResponse struct {
 	Priority     string
 	Retries      int
 	Timeout      string
-	Error        error
+	// Spec, when set, is returned as is in place of the fields above
+	Spec  *TaskSpec
+	Error error
+}
+
+// spec returns the TaskSpec the response describes
+func (r TaskResponse) spec() *TaskSpec {
+	if r.Spec != nil {
+		spec := *r.Spec
+		return &spec
+	}
+	return &TaskSpec{
+		Version:      TaskSpecVersion,
+		Command:      r.Command,
+		Dependencies: r.Dependencies,
+		Priority:     r.Priority,
+		Retries:      r.Retries,
+		Timeout:      r.Timeout,
+	}
 }
 
 func NewMockGopilot() *MockGopilot {
@@ -66,13 +88,17 @@ func (m *MockGopilot) SetResponse(path string, response TaskResponse) {
 }
 
 func (m *MockGopilot) GenerateTaskProps(ctx context.Context, taskPath string) (string, []string, string, int, string, error) {
+	return generateTaskProps(ctx, m, taskPath)
+}
+
+func (m *MockGopilot) ExtractTask(ctx context.Context, taskPath string) (*TaskSpec, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	// Always use normalized absolute path for lookup
 	absPath, err := filepath.Abs(taskPath)
 	if err != nil {
-		return "", nil, "", 0, "", fmt.Errorf("failed to get absolute path: %w", err)
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
 	}
 	normalizedPath := normalizePath(absPath)
 	fmt.Printf("Looking up response for normalized path: %s\n", normalizedPath)
@@ -81,14 +107,21 @@ func (m *MockGopilot) GenerateTaskProps(ctx context.Context, taskPath string) (s
 	if response, ok := m.responses[normalizedPath]; ok {
 		if response.Error != nil {
 			fmt.Printf("Mock returning error for path %s: %v\n", normalizedPath, response.Error)
-			return "", nil, "", 0, "", response.Error
+			return nil, response.Error
 		}
-		return response.Command, response.Dependencies, response.Priority, response.Retries, response.Timeout, nil
+		return response.spec(), nil
 	}
 
 	// Return default values if no response is configured
 	fmt.Printf("Mock using default response for path %s\n", normalizedPath)
-	return "echo default", []string{}, "medium", 1, "30m", nil
+	return &TaskSpec{
+		Version:      TaskSpecVersion,
+		Command:      "echo default",
+		Dependencies: []string{},
+		Priority:     "medium",
+		Retries:      1,
+		Timeout:      "30m",
+	}, nil
 }
 
 func (m *MockGopilot) GetResponses() map[string]TaskResponse {
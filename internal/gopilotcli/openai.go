@@ -0,0 +1,176 @@
+package gopilotcli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultOpenAITimeout bounds a single chat completions request when no
+// timeout is configured
+const DefaultOpenAITimeout = 60 * time.Second
+
+// openAIPromptVersion changes whenever openAISystemPrompt does, so that
+// cached extractions made with an older prompt are not reused
+const openAIPromptVersion = 1
+
+// openAISystemPrompt asks the model for a TaskSpec as a JSON object
+const openAISystemPrompt = `You extract task definitions from markdown files for a workflow runner.
+Reply with a single JSON object and nothing else, using these fields:
+  "command": the shell command that performs the task (string, required)
+  "dependencies": names of tasks this task depends on (array of strings)
+  "priority": one of "high", "medium" or "low"
+  "retries": how many times to retry the command on failure (integer)
+  "timeout": a Go duration such as "30m" (string)
+  "env": extra environment variables for the command (object of strings)
+  "workdir": directory to run the command in, relative to the task file (string)
+  "inputs": files or data the task reads (array of strings)
+  "outputs": files or data the task produces (array of strings)
+  "conditions": conditions that must hold for the task to run (array of strings)
+  "description": a one-sentence summary of the task (string)
+  "confidence": how sure you are of the extraction, from 0 to 1 (number)
+Leave out fields the task doesn't mention.`
+
+// OpenAIGopilot extracts tasks by asking any OpenAI-compatible chat
+// completions endpoint for structured JSON
+type OpenAIGopilot struct {
+	// BaseURL is the API root, such as https://api.openai.com/v1 or
+	// http://localhost:11434/v1
+	BaseURL string
+	Model   string
+	// APIKeyEnv names the environment variable holding the API key. No
+	// Authorization header is sent when it is empty or unset.
+	APIKeyEnv string
+	Timeout   time.Duration
+
+	client *http.Client
+}
+
+// NewOpenAIGopilot creates an extractor for the given endpoint and model. A
+// zero timeout uses DefaultOpenAITimeout.
+func NewOpenAIGopilot(baseURL, model, apiKeyEnv string, timeout time.Duration) *OpenAIGopilot {
+	if timeout <= 0 {
+		timeout = DefaultOpenAITimeout
+	}
+	return &OpenAIGopilot{
+		BaseURL:   strings.TrimSuffix(baseURL, "/"),
+		Model:     model,
+		APIKeyEnv: apiKeyEnv,
+		Timeout:   timeout,
+		client:    &http.Client{},
+	}
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model          string            `json:"model"`
+	Messages       []chatMessage     `json:"messages"`
+	Temperature    float64           `json:"temperature"`
+	ResponseFormat map[string]string `json:"response_format"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+// CacheKey identifies the endpoint, model and prompt whose output is being
+// cached
+func (g *OpenAIGopilot) CacheKey() string {
+	return fmt.Sprintf("openai:%s:%s:prompt-v%d", g.BaseURL, g.Model, openAIPromptVersion)
+}
+
+func (g *OpenAIGopilot) GenerateTaskProps(ctx context.Context, taskPath string) (string, []string, string, int, string, error) {
+	return generateTaskProps(ctx, g, taskPath)
+}
+
+func (g *OpenAIGopilot) ExtractTask(ctx context.Context, taskPath string) (*TaskSpec, error) {
+	content, err := os.ReadFile(taskPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task file: %w", err)
+	}
+
+	body, err := json.Marshal(chatRequest{
+		Model: g.Model,
+		Messages: []chatMessage{
+			{Role: "system", Content: openAISystemPrompt},
+			{Role: "user", Content: string(content)},
+		},
+		ResponseFormat: map[string]string{"type": "json_object"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode chat completions request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, g.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.BaseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat completions request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.APIKeyEnv != "" {
+		if key := os.Getenv(g.APIKeyEnv); key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("chat completions request to %s failed: %w", g.BaseURL, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat completions response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("chat completions request failed with status %d: %s", resp.StatusCode, diagnosticsFrom(string(data)))
+	}
+
+	var chat chatResponse
+	if err := json.Unmarshal(data, &chat); err != nil {
+		return nil, fmt.Errorf("invalid chat completions response: %w", err)
+	}
+	if len(chat.Choices) == 0 {
+		return nil, fmt.Errorf("chat completions response has no choices")
+	}
+
+	var spec TaskSpec
+	if err := json.Unmarshal([]byte(stripJSONFence(chat.Choices[0].Message.Content)), &spec); err != nil {
+		return nil, fmt.Errorf("model returned invalid task JSON for %s: %w", taskPath, err)
+	}
+	if spec.Command == "" {
+		return nil, fmt.Errorf("model returned no command for %s", taskPath)
+	}
+
+	spec.Version = TaskSpecVersion
+	if spec.Dependencies == nil {
+		spec.Dependencies = []string{}
+	}
+	return &spec, nil
+}
+
+// stripJSONFence unwraps a reply the model wrapped in a ```json code fence
+func stripJSONFence(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	content = strings.TrimPrefix(content, "```")
+	content = strings.TrimPrefix(content, "json")
+	return strings.TrimSpace(strings.TrimSuffix(content, "```"))
+}
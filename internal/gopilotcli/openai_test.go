@@ -0,0 +1,90 @@
+package gopilotcli
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIGopilotExtractTask(t *testing.T) {
+	taskPath := filepath.Join(t.TempDir(), "build.md")
+	if err := os.WriteFile(taskPath, []byte("# Build\n\nRun make build after fetch.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_OPENAI_KEY", "secret")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("unexpected Authorization header %q", auth)
+		}
+
+		var req chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Model != "local-model" || len(req.Messages) != 2 || !strings.Contains(req.Messages[1].Content, "make build") {
+			t.Errorf("unexpected request %+v", req)
+		}
+
+		content := "```json\n{\"command\": \"make build\", \"dependencies\": [\"fetch\"], \"priority\": \"high\", \"retries\": 1, \"timeout\": \"5m\"}\n```"
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{
+				{"message": map[string]string{"role": "assistant", "content": content}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	g := NewOpenAIGopilot(server.URL+"/v1/", "local-model", "TEST_OPENAI_KEY", 0)
+	spec, err := g.ExtractTask(context.Background(), taskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Version != TaskSpecVersion || spec.Command != "make build" || spec.Priority != "high" || spec.Retries != 1 || spec.Timeout != "5m" {
+		t.Errorf("unexpected spec %+v", spec)
+	}
+	if len(spec.Dependencies) != 1 || spec.Dependencies[0] != "fetch" {
+		t.Errorf("unexpected dependencies %v", spec.Dependencies)
+	}
+}
+
+func TestOpenAIGopilotErrors(t *testing.T) {
+	taskPath := filepath.Join(t.TempDir(), "build.md")
+	if err := os.WriteFile(taskPath, []byte("# Build\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		status int
+		body   string
+		want   string
+	}{
+		"error status": {http.StatusUnauthorized, `{"error": "bad key"}`, "status 401: {\"error\": \"bad key\"}"},
+		"no choices":   {http.StatusOK, `{"choices": []}`, "no choices"},
+		"invalid task": {http.StatusOK, `{"choices": [{"message": {"content": "sorry"}}]}`, "invalid task JSON"},
+		"no command":   {http.StatusOK, `{"choices": [{"message": {"content": "{}"}}]}`, "no command"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			_, err := NewOpenAIGopilot(server.URL, "local-model", "", 0).ExtractTask(context.Background(), taskPath)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package gopilotcli
+
+import "context"
+
+// TaskSpecVersion is the version of the TaskSpec schema produced by this
+// package. Extractors stamp it on every spec they return so that callers can
+// reject specs written for a newer schema.
+const TaskSpecVersion = 1
+
+// TaskSpec is everything an extractor could work out about a task from its
+// markdown file. Fields that weren't found are left at their zero value.
+type TaskSpec struct {
+	Version      int               `json:"version"`
+	Command      string            `json:"command"`
+	Dependencies []string          `json:"dependencies"`
+	Priority     string            `json:"priority"`
+	Retries      int               `json:"retries"`
+	Timeout      string            `json:"timeout"`
+	Env          map[string]string `json:"env,omitempty"`
+	Workdir      string            `json:"workdir,omitempty"`
+	Inputs       []string          `json:"inputs,omitempty"`
+	Outputs      []string          `json:"outputs,omitempty"`
+	Conditions   []string          `json:"conditions,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	// Confidence is how sure the extractor is of the spec, from 0 to 1. Zero
+	// means the extractor doesn't report one.
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+// Extractor turns a task markdown file into a TaskSpec
+type Extractor interface {
+	ExtractTask(ctx context.Context, taskPath string) (*TaskSpec, error)
+}
+
+// NewExtractor returns g as an Extractor. Implementations that only provide
+// the older GenerateTaskProps method are wrapped in an adapter.
+func NewExtractor(g GopilotCLI) Extractor {
+	if e, ok := g.(Extractor); ok {
+		return e
+	}
+	return legacyExtractor{g}
+}
+
+// legacyExtractor adapts a GopilotCLI that only returns the property tuple
+type legacyExtractor struct {
+	gopilot GopilotCLI
+}
+
+func (l legacyExtractor) ExtractTask(ctx context.Context, taskPath string) (*TaskSpec, error) {
+	command, deps, priority, retries, timeout, err := l.gopilot.GenerateTaskProps(ctx, taskPath)
+	if err != nil {
+		return nil, err
+	}
+	return &TaskSpec{
+		Version:      TaskSpecVersion,
+		Command:      command,
+		Dependencies: deps,
+		Priority:     priority,
+		Retries:      retries,
+		Timeout:      timeout,
+	}, nil
+}
+
+// generateTaskProps flattens the spec returned by an extractor into the
+// GenerateTaskProps tuple
+func generateTaskProps(ctx context.Context, e Extractor, taskPath string) (string, []string, string, int, string, error) {
+	spec, err := e.ExtractTask(ctx, taskPath)
+	if err != nil {
+		return "", nil, "", 0, "", err
+	}
+	return spec.Command, spec.Dependencies, spec.Priority, spec.Retries, spec.Timeout, nil
+}
@@ -0,0 +1,226 @@
+// Package history keeps an immutable record of every apply under
+// .tgfs/runs/<run-id>/run.json, next to the run's task logs, so that past
+// applies can be listed and inspected after the state file has moved on.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/zackiles/task-graph-fs/internal/fsutil"
+	"github.com/zackiles/task-graph-fs/internal/planfile"
+	"github.com/zackiles/task-graph-fs/internal/state"
+	"github.com/zackiles/task-graph-fs/internal/workspace"
+)
+
+// Version is the version of the run record format
+const Version = 1
+
+// RecordFileName is the name of the run record inside a run's directory
+const RecordFileName = "run.json"
+
+// Record describes a finished apply: what it ran and how every task ended
+type Record struct {
+	Version     int       `json:"version"`
+	RunID       string    `json:"run_id"`
+	WorkflowDir string    `json:"workflow_dir"`
+	StatePath   string    `json:"state_path"`
+	StartedAt   time.Time `json:"started_at"`
+	EndedAt     time.Time `json:"ended_at"`
+	Duration    string    `json:"duration"`
+	// Status is completed, failed or cancelled
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// LogDir is the run directory holding the task logs, relative to the
+	// workspace root
+	LogDir string `json:"log_dir"`
+	// Workflows are the resolved task specs and dependency graph that ran
+	Workflows []planfile.Workflow `json:"workflows"`
+	// Results is the state of every workflow and task at the end of the run
+	Results []state.WorkflowState `json:"results"`
+}
+
+// Warnings receives a warning for every run whose record can't be read
+var Warnings io.Writer = os.Stderr
+
+// Retention limits how many run records are kept. Zero values keep
+// everything.
+type Retention struct {
+	// Keep is the number of most recent runs to keep
+	Keep int
+	// MaxAge removes runs that started longer ago than this
+	MaxAge time.Duration
+}
+
+// RecordPath returns the path of a run's record
+func RecordPath(root, runID string) string {
+	return filepath.Join(workspace.RunDir(root, runID), RecordFileName)
+}
+
+// Write saves the record of a run. Records are written once, when the run
+// has finished.
+func Write(root string, record *Record) error {
+	record.Version = Version
+	record.LogDir = filepath.ToSlash(filepath.Join(workspace.MetadataDir, "runs", record.RunID))
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run record: %w", err)
+	}
+
+	path := RecordPath(root, record.RunID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create run directory: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write run record: %w", err)
+	}
+	return nil
+}
+
+// Read loads the record of a run
+func Read(root, runID string) (*Record, error) {
+	path := RecordPath(root, runID)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no record of run %s, see `tgfs history` for the recorded runs", runID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run record: %w", err)
+	}
+
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to parse run record %s: %w", path, err)
+	}
+	if record.Version > Version {
+		return nil, fmt.Errorf("unsupported run record version %d in %s, expected up to %d", record.Version, path, Version)
+	}
+	return &record, nil
+}
+
+// List returns the record of every recorded run, most recent first. Run
+// directories without a record, such as those of an apply that crashed, are
+// left out, and records that can't be read are skipped with a warning.
+func List(root string) ([]*Record, error) {
+	runIDs, err := recordedRuns(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*Record
+	for _, runID := range runIDs {
+		record, err := Read(root, runID)
+		if err != nil {
+			fmt.Fprintf(Warnings, "Warning: skipping run %s: %v\n", runID, err)
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if !records[i].StartedAt.Equal(records[j].StartedAt) {
+			return records[i].StartedAt.After(records[j].StartedAt)
+		}
+		return records[i].RunID > records[j].RunID
+	})
+	return records, nil
+}
+
+// Prune removes the directories, logs included, of recorded runs beyond the
+// retention limits, newest first. A run whose record can't be read is still
+// pruned in turn, dated by its ID instead. Runs listed in keep, such as those
+// whose logs the current state still points to, are never removed. It returns
+// the removed run IDs.
+func Prune(root string, retention Retention, now time.Time, keep map[string]bool) ([]string, error) {
+	if retention.Keep <= 0 && retention.MaxAge <= 0 {
+		return nil, nil
+	}
+
+	runIDs, err := recordedRuns(root)
+	if err != nil {
+		return nil, err
+	}
+	started := make(map[string]time.Time, len(runIDs))
+	for _, runID := range runIDs {
+		started[runID] = startTime(root, runID)
+	}
+	sort.Slice(runIDs, func(i, j int) bool {
+		a, b := runIDs[i], runIDs[j]
+		if !started[a].Equal(started[b]) {
+			return started[a].After(started[b])
+		}
+		return a > b
+	})
+
+	var removed []string
+	for i, runID := range runIDs {
+		expired := retention.Keep > 0 && i >= retention.Keep
+		if retention.MaxAge > 0 && !started[runID].IsZero() && now.Sub(started[runID]) > retention.MaxAge {
+			expired = true
+		}
+		if !expired || keep[runID] {
+			continue
+		}
+		if err := os.RemoveAll(workspace.RunDir(root, runID)); err != nil {
+			return removed, fmt.Errorf("failed to remove run %s: %w", runID, err)
+		}
+		removed = append(removed, runID)
+	}
+	return removed, nil
+}
+
+// recordedRuns returns the IDs of the runs that have a record
+func recordedRuns(root string) ([]string, error) {
+	entries, err := os.ReadDir(workspace.RunsDir(root))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	var runIDs []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(RecordPath(root, entry.Name())); err != nil {
+			continue
+		}
+		runIDs = append(runIDs, entry.Name())
+	}
+	return runIDs, nil
+}
+
+// startTime returns when a run started, from its record or, when that can't
+// be read, from its ID. It is zero when neither tells.
+func startTime(root, runID string) time.Time {
+	if record, err := Read(root, runID); err == nil {
+		return record.StartedAt
+	}
+	started, _ := workspace.RunStartTime(runID)
+	return started
+}
+
+// ReferencedRuns returns the runs whose logs the state points to
+func ReferencedRuns(st *state.StateFile) map[string]bool {
+	runs := make(map[string]bool)
+	if st.RunID != "" {
+		runs[st.RunID] = true
+	}
+	for _, w := range st.Workflows {
+		for _, t := range w.Tasks {
+			if t.RunID != "" {
+				runs[t.RunID] = true
+			}
+		}
+	}
+	return runs
+}
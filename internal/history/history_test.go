@@ -0,0 +1,176 @@
+package history
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zackiles/task-graph-fs/internal/state"
+	"github.com/zackiles/task-graph-fs/internal/workspace"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	root := t.TempDir()
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	record := &Record{
+		RunID:     "run-1",
+		StartedAt: started,
+		Status:    "failed",
+		Error:     "task lint failed",
+		Results: []state.WorkflowState{{
+			WorkflowID: "ci",
+			Status:     "failed",
+			Tasks:      []state.TaskState{{ID: "lint", Status: "failed"}},
+		}},
+	}
+	if err := Write(root, record); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Read(root, "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != Version || got.LogDir != ".tgfs/runs/run-1" {
+		t.Errorf("unexpected version or log dir: %+v", got)
+	}
+	if !got.StartedAt.Equal(started) || got.Status != "failed" || got.Results[0].Tasks[0].ID != "lint" {
+		t.Errorf("record did not round-trip: %+v", got)
+	}
+
+	if _, err := Read(root, "missing"); err == nil {
+		t.Error("expected reading an unknown run to fail")
+	}
+}
+
+func TestListAndPrune(t *testing.T) {
+	root := t.TempDir()
+	now := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+
+	for i, id := range []string{"run-1", "run-2", "run-3", "run-4"} {
+		record := &Record{RunID: id, StartedAt: now.Add(time.Duration(i-3) * 24 * time.Hour)}
+		if err := Write(root, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A run that crashed before its record was written is not listed
+	if err := os.MkdirAll(workspace.RunDir(root, "run-crashed"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := List(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := runIDs(records); len(ids) != 4 || ids[0] != "run-4" || ids[3] != "run-1" {
+		t.Fatalf("expected runs newest first, got %v", ids)
+	}
+
+	if removed, err := Prune(root, Retention{}, now, nil); err != nil || len(removed) != 0 {
+		t.Fatalf("expected no retention to keep every run, removed %v: %v", removed, err)
+	}
+
+	// run-1 is referenced by the state and survives both limits
+	removed, err := Prune(root, Retention{Keep: 2, MaxAge: 36 * time.Hour}, now, map[string]bool{"run-1": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "run-2" {
+		t.Errorf("expected only run-2 to be pruned, got %v", removed)
+	}
+
+	records, err = List(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := runIDs(records); len(ids) != 3 || ids[0] != "run-4" || ids[1] != "run-3" || ids[2] != "run-1" {
+		t.Errorf("unexpected runs after pruning: %v", ids)
+	}
+}
+
+func TestBadRecords(t *testing.T) {
+	root := t.TempDir()
+	now := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+
+	var warnings bytes.Buffer
+	Warnings = &warnings
+	defer func() { Warnings = os.Stderr }()
+
+	for _, id := range []string{"20240508T000000Z-aaaaaa", "20240510T000000Z-cccccc"} {
+		started, _ := workspace.RunStartTime(id)
+		if err := Write(root, &Record{RunID: id, StartedAt: started}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A truncated record and one written by a newer version
+	bad := map[string]string{
+		"20240509T000000Z-bbbbbb": `{"version": 1, "run_id": "2024`,
+		"20240507T000000Z-dddddd": `{"version": 99, "run_id": "20240507T000000Z-dddddd"}`,
+	}
+	for id, content := range bad {
+		path := RecordPath(root, id)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := List(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := runIDs(records); len(ids) != 2 || ids[0] != "20240510T000000Z-cccccc" || ids[1] != "20240508T000000Z-aaaaaa" {
+		t.Errorf("expected the readable runs, got %v", ids)
+	}
+	for id := range bad {
+		if !strings.Contains(warnings.String(), "skipping run "+id) {
+			t.Errorf("expected a warning about run %s, got %q", id, warnings.String())
+		}
+	}
+
+	// Unreadable records are aged by their ID
+	removed, err := Prune(root, Retention{MaxAge: 36 * time.Hour}, now, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || removed[0] != "20240508T000000Z-aaaaaa" || removed[1] != "20240507T000000Z-dddddd" {
+		t.Errorf("expected the runs older than 36h to be pruned, got %v", removed)
+	}
+
+	// and counted in order of their ID
+	removed, err = Prune(root, Retention{Keep: 1}, now, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "20240509T000000Z-bbbbbb" {
+		t.Errorf("expected the truncated run to be pruned, got %v", removed)
+	}
+
+	// Readable records are ordered by when they started, which IDs only
+	// tell to the second
+	started, _ := workspace.RunStartTime("20240510T000000Z-cccccc")
+	if err := Write(root, &Record{RunID: "20240510T000000Z-000000", StartedAt: started.Add(time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	}
+	removed, err = Prune(root, Retention{Keep: 1}, now, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "20240510T000000Z-cccccc" {
+		t.Errorf("expected the earlier run of the same second to be pruned, got %v", removed)
+	}
+}
+
+func runIDs(records []*Record) []string {
+	ids := make([]string, len(records))
+	for i, r := range records {
+		ids[i] = r.RunID
+	}
+	return ids
+}
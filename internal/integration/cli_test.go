@@ -3,6 +3,7 @@ package integration
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/zackiles/task-graph-fs/cmd"
 	"github.com/zackiles/task-graph-fs/internal/gopilotcli"
+	"github.com/zackiles/task-graph-fs/internal/report"
 	"github.com/zackiles/task-graph-fs/internal/state"
 	"github.com/zackiles/task-graph-fs/internal/testutils"
 )
@@ -180,6 +182,858 @@ func TestCLI(t *testing.T) {
 		}
 	})
 
+	testutils.RunTestWithName(t, "Task Logs", func(t *testing.T) {
+		env := setupTest(t)
+
+		err := createTestWorkflow(env.rootDir, "logged", []string{"chatty"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		env.mockGopilot.SetResponse(
+			filepath.Join(env.rootDir, "logged", "chatty.md"),
+			gopilotcli.TaskResponse{
+				Command:      "echo hello from chatty && echo warning from chatty >&2",
+				Dependencies: []string{},
+				Priority:     "medium",
+				Retries:      0,
+				Timeout:      "1m",
+			},
+		)
+
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err != nil {
+			t.Fatal(err)
+		}
+
+		currentState, err := state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if currentState.RunID == "" {
+			t.Fatal("expected apply to record a run ID")
+		}
+		if !strings.Contains(currentState.Workflows[0].Tasks[0].Output, "hello from chatty") {
+			t.Errorf("expected output tail in state, got %q", currentState.Workflows[0].Tasks[0].Output)
+		}
+
+		output, err := executeCommandOutput(env.ctx, "logs", "logged/chatty")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(output, "hello from chatty") || !strings.Contains(output, "warning from chatty") {
+			t.Errorf("expected logs to contain stdout and stderr, got %q", output)
+		}
+	})
+
+	testutils.RunTestWithName(t, "Follow Task Logs", func(t *testing.T) {
+		env := setupTest(t)
+
+		err := createTestWorkflow(env.rootDir, "followed", []string{"slow"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		env.mockGopilot.SetResponse(
+			filepath.Join(env.rootDir, "followed", "slow.md"),
+			gopilotcli.TaskResponse{
+				Command:  "echo first line && sleep 1 && echo last line",
+				Priority: "medium",
+				Timeout:  "1m",
+			},
+		)
+
+		applied := make(chan error, 1)
+		go func() {
+			applied <- executeCommand(env.ctx, "apply", "--auto-approve")
+		}()
+
+		// Wait for the task to start writing its log
+		for {
+			if _, err := executeCommandOutput(env.ctx, "logs", "followed/slow"); err == nil {
+				break
+			}
+			select {
+			case err := <-applied:
+				t.Fatalf("apply finished before the task's log could be read: %v", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(env.ctx, 10*time.Second)
+		defer cancel()
+		output, err := executeCommandOutput(ctx, "logs", "--follow", "followed/slow")
+		if err != nil {
+			t.Fatalf("expected follow to return once the task finished, got: %v", err)
+		}
+		if ctx.Err() != nil {
+			t.Fatal("expected follow to return before being interrupted")
+		}
+		if !strings.Contains(output, "first line") || !strings.Contains(output, "last line") {
+			t.Errorf("expected the task's whole log, got %q", output)
+		}
+
+		if err := <-applied; err != nil {
+			t.Fatal(err)
+		}
+
+		// Following a finished task prints its log and returns straight away
+		output, err = executeCommandOutput(ctx, "logs", "-f", "followed/slow")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(output, "last line") {
+			t.Errorf("expected the task's log, got %q", output)
+		}
+	})
+
+	testutils.RunTestWithName(t, "Resume After Failure", func(t *testing.T) {
+		env := setupTest(t)
+
+		err := createTestWorkflow(env.rootDir, "resumable", []string{"fetch", "train"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := createDependencyLink(env.rootDir, "resumable", "train", "fetch"); err != nil {
+			t.Fatal(err)
+		}
+
+		fetchCount := filepath.Join(env.rootDir, "fetch.count")
+		ready := filepath.Join(env.rootDir, "ready")
+
+		env.mockGopilot.SetResponse(
+			filepath.Join(env.rootDir, "resumable", "fetch.md"),
+			gopilotcli.TaskResponse{
+				Command:  fmt.Sprintf("echo run >> %s", fetchCount),
+				Priority: "medium",
+				Timeout:  "1m",
+			},
+		)
+		env.mockGopilot.SetResponse(
+			filepath.Join(env.rootDir, "resumable", "train.md"),
+			gopilotcli.TaskResponse{
+				Command:  fmt.Sprintf("test -f %s", ready),
+				Priority: "medium",
+				Timeout:  "1m",
+			},
+		)
+
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err == nil {
+			t.Fatal("expected first apply to fail")
+		}
+
+		currentState, err := state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyWorkflowState(t, currentState, "resumable", "failed")
+		verifyTaskState(t, currentState, "resumable", "fetch", "completed")
+		verifyTaskState(t, currentState, "resumable", "train", "failed")
+
+		if err := os.WriteFile(ready, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err != nil {
+			t.Fatal(err)
+		}
+
+		currentState, err = state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyWorkflowState(t, currentState, "resumable", "completed")
+		verifyTaskState(t, currentState, "resumable", "train", "completed")
+
+		runs, err := os.ReadFile(fetchCount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(string(runs), "run") != 1 {
+			t.Errorf("expected completed task to be resumed past, but it ran %d times", strings.Count(string(runs), "run"))
+		}
+	})
+
+	testutils.RunTestWithName(t, "Resume Reruns Downstream", func(t *testing.T) {
+		env := setupTest(t)
+
+		ready := filepath.Join(env.rootDir, "ready")
+		counted := func(id string) string {
+			return fmt.Sprintf("echo run >> %s", filepath.Join(env.rootDir, id+".count"))
+		}
+		runs := func(id string) int {
+			data, _ := os.ReadFile(filepath.Join(env.rootDir, id+".count"))
+			return strings.Count(string(data), "run")
+		}
+
+		// a -> b -> c, where c fails until ready exists
+		if err := createStructuredTask(env.rootDir, "chain", "a", counted("a")); err != nil {
+			t.Fatal(err)
+		}
+		if err := createStructuredTask(env.rootDir, "chain", "b", counted("b")); err != nil {
+			t.Fatal(err)
+		}
+		if err := createStructuredTask(env.rootDir, "chain", "c", counted("c")+" && test -f "+ready); err != nil {
+			t.Fatal(err)
+		}
+		if err := createDependencyLink(env.rootDir, "chain", "b", "a"); err != nil {
+			t.Fatal(err)
+		}
+		if err := createDependencyLink(env.rootDir, "chain", "c", "b"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err == nil {
+			t.Fatal("expected c to fail")
+		}
+
+		// Editing a so that it fails must hold back b and c
+		if err := createStructuredTask(env.rootDir, "chain", "a", counted("a")+" && exit 1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err == nil {
+			t.Fatal("expected a to fail")
+		}
+		currentState, err := state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyTaskState(t, currentState, "chain", "a", "failed")
+		verifyTaskState(t, currentState, "chain", "b", "skipped")
+		verifyTaskState(t, currentState, "chain", "c", "skipped")
+		if runs("c") != 1 {
+			t.Errorf("expected c not to run while a failed, it ran %d times", runs("c"))
+		}
+
+		// Once a is fixed everything downstream of it runs again
+		if err := createStructuredTask(env.rootDir, "chain", "a", counted("a")+" && echo fixed"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(ready, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err != nil {
+			t.Fatal(err)
+		}
+		currentState, err = state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyWorkflowState(t, currentState, "chain", "completed")
+		if runs("b") != 2 || runs("c") != 2 {
+			t.Errorf("expected b and c to run again after a, got %d and %d runs", runs("b"), runs("c"))
+		}
+		b := currentState.FindWorkflow("chain").FindTask("b")
+		if b.RunID != currentState.RunID {
+			t.Errorf("expected b to be refreshed in the latest run, got run %s", b.RunID)
+		}
+	})
+
+	testutils.RunTestWithName(t, "Cross-Workflow Dependencies", func(t *testing.T) {
+		env := setupTest(t)
+
+		if err := createTestWorkflow(env.rootDir, "data-pipeline", []string{"transform-data"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := createTestWorkflow(env.rootDir, "model-training", []string{"prepare-features"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(
+			"../data-pipeline/transform-data.md",
+			filepath.Join(env.rootDir, "model-training", "prepare-features_dependencies"),
+		); err != nil {
+			t.Fatal(err)
+		}
+
+		marker := filepath.Join(env.rootDir, "transformed")
+		env.mockGopilot.SetResponse(
+			filepath.Join(env.rootDir, "data-pipeline", "transform-data.md"),
+			gopilotcli.TaskResponse{
+				Command:  fmt.Sprintf("sleep 0.3 && touch %s", marker),
+				Priority: "medium",
+				Timeout:  "1m",
+			},
+		)
+		env.mockGopilot.SetResponse(
+			filepath.Join(env.rootDir, "model-training", "prepare-features.md"),
+			gopilotcli.TaskResponse{
+				Command:  fmt.Sprintf("test -f %s", marker),
+				Priority: "medium",
+				Timeout:  "1m",
+			},
+		)
+
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err != nil {
+			t.Fatal(err)
+		}
+
+		currentState, err := state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyTaskState(t, currentState, "data-pipeline", "transform-data", "completed")
+		verifyTaskState(t, currentState, "model-training", "prepare-features", "completed")
+	})
+
+	testutils.RunTestWithName(t, "Validate Rejects Cycles", func(t *testing.T) {
+		env := setupTest(t)
+
+		if err := createTestWorkflow(env.rootDir, "cyclic", []string{"taskA", "taskB"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := createDependencyLink(env.rootDir, "cyclic", "taskA", "taskB"); err != nil {
+			t.Fatal(err)
+		}
+		if err := createDependencyLink(env.rootDir, "cyclic", "taskB", "taskA"); err != nil {
+			t.Fatal(err)
+		}
+
+		output, err := executeCommandOutput(env.ctx, "validate")
+		if err == nil {
+			t.Fatal("expected validate to fail on a cycle")
+		}
+		if !strings.Contains(output, "cyclic/taskA -> cyclic/taskB -> cyclic/taskA") {
+			t.Errorf("expected the cycle path in the output, got %q", output)
+		}
+		if strings.Contains(output, "Usage:") {
+			t.Errorf("expected no usage text after the problems, got %q", output)
+		}
+
+		if err := executeCommand(env.ctx, "plan"); err == nil {
+			t.Fatal("expected plan to fail on a cycle")
+		}
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err == nil {
+			t.Fatal("expected apply to fail on a cycle")
+		}
+	})
+
+	testutils.RunTestWithName(t, "Errors Without Usage", func(t *testing.T) {
+		env := setupTest(t)
+
+		if err := createStructuredTask(env.rootDir, "broken", "build", "exit 1"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Failures are left for main to report once, without usage text
+		for _, args := range [][]string{{"apply", "--auto-approve"}, {"apply", "missing.tgfs"}, {"plan", "--dir", "missing"}} {
+			var out bytes.Buffer
+			root := cmd.NewRootCommand()
+			root.SetContext(env.ctx)
+			root.SetArgs(args)
+			root.SetOut(&out)
+			root.SetErr(&out)
+			if err := runCommand(env.ctx, root.Execute); err == nil {
+				t.Fatalf("expected %v to fail", args)
+			}
+			if strings.Contains(out.String(), "Usage:") || strings.Contains(out.String(), "Error:") {
+				t.Errorf("expected %v to print neither usage nor the error, got %q", args, out.String())
+			}
+		}
+
+		// Mistakes in the arguments still show how to use the command
+		var out bytes.Buffer
+		root := cmd.NewRootCommand()
+		root.SetArgs([]string{"apply", "--no-such-flag"})
+		root.SetOut(&out)
+		root.SetErr(&out)
+		if err := root.Execute(); err == nil || !strings.Contains(out.String(), "Usage:") {
+			t.Errorf("expected an unknown flag to show usage, got %v: %q", err, out.String())
+		}
+	})
+
+	testutils.RunTestWithName(t, "Structured Tasks", func(t *testing.T) {
+		env := setupTest(t)
+
+		marker := filepath.Join(env.rootDir, "structured.out")
+		if err := createStructuredTask(env.rootDir, "structured", "build", "echo built > "+marker); err != nil {
+			t.Fatal(err)
+		}
+
+		// The documented sections are authoritative, so gopilot isn't consulted
+		env.mockGopilot.SetResponse(
+			filepath.Join(env.rootDir, "structured", "build.md"),
+			gopilotcli.TaskResponse{Error: fmt.Errorf("gopilot should not be called for structured tasks")},
+		)
+
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(marker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "built\n" {
+			t.Errorf("expected the structured command to run, got %q", data)
+		}
+	})
+
+	testutils.RunTestWithName(t, "Saved Plan", func(t *testing.T) {
+		env := setupTest(t)
+
+		marker := filepath.Join(env.rootDir, "planned.out")
+		if err := createStructuredTask(env.rootDir, "planned", "build", "echo planned >> "+marker); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := executeCommand(env.ctx, "plan", "--out", "plan.tgfs"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Editing a task after planning makes the saved plan stale
+		if err := createStructuredTask(env.rootDir, "planned", "build", "echo edited >> "+marker); err != nil {
+			t.Fatal(err)
+		}
+		err := executeCommand(env.ctx, "apply", "plan.tgfs")
+		if err == nil || !strings.Contains(err.Error(), "changed since the plan was made") {
+			t.Fatalf("expected a stale plan to be refused, got %v", err)
+		}
+		if _, err := os.Stat(marker); !os.IsNotExist(err) {
+			t.Fatal("expected nothing to run from a stale plan")
+		}
+
+		if err := executeCommand(env.ctx, "plan", "--out", "plan.tgfs"); err != nil {
+			t.Fatal(err)
+		}
+		if err := executeCommand(env.ctx, "apply", "plan.tgfs"); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(marker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "edited\n" {
+			t.Errorf("expected the planned command to run once, got %q", data)
+		}
+
+		// Applying changed the state, so the plan can't be applied again
+		err = executeCommand(env.ctx, "apply", "plan.tgfs")
+		if err == nil || !strings.Contains(err.Error(), "changed since the plan was made") {
+			t.Errorf("expected an applied plan to be stale, got %v", err)
+		}
+	})
+
+	testutils.RunTestWithName(t, "No Changes After Apply", func(t *testing.T) {
+		env := setupTest(t)
+
+		marker := filepath.Join(env.rootDir, "runs.out")
+		if err := createStructuredTask(env.rootDir, "stable", "build", "echo run >> "+marker); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := executeCommand(env.ctx, "apply", "--auto-approve"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		data, err := os.ReadFile(marker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "run\n" {
+			t.Errorf("expected an unchanged, completed task not to run again, got %q", data)
+		}
+	})
+
+	testutils.RunTestWithName(t, "State In Workspace Root", func(t *testing.T) {
+		env := setupTest(t)
+
+		workspaceDir := filepath.Join(env.rootDir, "ws")
+		if err := createStructuredTask(workspaceDir, "ci", "build", "echo build"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := executeCommand(env.ctx, "plan", "--dir", "ws"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(workspaceDir, ".tgfs-plan")); err != nil {
+			t.Errorf("expected the plan to be saved in the workspace root: %v", err)
+		}
+
+		if err := executeCommand(env.ctx, "apply", "--dir", "ws", "--auto-approve"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(env.rootDir, "tgfs-state.json")); !os.IsNotExist(err) {
+			t.Error("expected no state file in the current directory")
+		}
+		wsState, err := state.LoadStateFrom(env.ctx, filepath.Join(workspaceDir, "tgfs-state.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyWorkflowState(t, wsState, "ci", "completed")
+
+		// A separate state file tracks the same workspace independently
+		if err := executeCommand(env.ctx, "apply", "--dir", "ws", "--state", "other.json", "--auto-approve"); err != nil {
+			t.Fatal(err)
+		}
+		otherState, err := state.LoadStateFrom(env.ctx, filepath.Join(env.rootDir, "other.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyWorkflowState(t, otherState, "ci", "completed")
+
+		// tgfs.yaml paths are relative to the workspace root
+		config := []byte("state:\n  path: .state/ci.json\n")
+		if err := os.WriteFile(filepath.Join(workspaceDir, "tgfs.yaml"), config, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := executeCommand(env.ctx, "apply", "--dir", "ws", "--auto-approve"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(workspaceDir, ".state", "ci.json")); err != nil {
+			t.Errorf("expected the configured state file to be written: %v", err)
+		}
+	})
+
+	testutils.RunTestWithName(t, "Saved Plan From Another Directory", func(t *testing.T) {
+		env := setupTest(t)
+
+		workspaceDir := filepath.Join(env.rootDir, "ws")
+		if err := createStructuredTask(workspaceDir, "ci", "build", "echo build"); err != nil {
+			t.Fatal(err)
+		}
+		if err := executeCommand(env.ctx, "plan", "--dir", "ws"); err != nil {
+			t.Fatal(err)
+		}
+
+		elsewhere := filepath.Join(env.rootDir, "elsewhere")
+		if err := os.Mkdir(elsewhere, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chdir(elsewhere); err != nil {
+			t.Fatal(err)
+		}
+		if err := executeCommand(env.ctx, "apply", filepath.Join("..", "ws", ".tgfs-plan")); err != nil {
+			t.Fatalf("expected the plan to apply from another directory, got %v", err)
+		}
+		wsState, err := state.LoadStateFrom(env.ctx, filepath.Join(workspaceDir, "tgfs-state.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyWorkflowState(t, wsState, "ci", "completed")
+		if _, err := os.Stat(filepath.Join(elsewhere, "ws")); !os.IsNotExist(err) {
+			t.Error("expected no workspace to be created in the current directory")
+		}
+
+		// A plan whose workspace is gone is refused without recreating it
+		if err := os.Chdir(env.rootDir); err != nil {
+			t.Fatal(err)
+		}
+		if err := createStructuredTask(workspaceDir, "ci", "build", "echo rebuild"); err != nil {
+			t.Fatal(err)
+		}
+		if err := executeCommand(env.ctx, "plan", "--dir", "ws", "--out", "moved.tgfs"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(workspaceDir, filepath.Join(env.rootDir, "moved")); err != nil {
+			t.Fatal(err)
+		}
+		err = executeCommand(env.ctx, "apply", "moved.tgfs")
+		if err == nil || !strings.Contains(err.Error(), "doesn't exist") {
+			t.Errorf("expected a missing workspace to be reported, got %v", err)
+		}
+		if _, err := os.Stat(workspaceDir); !os.IsNotExist(err) {
+			t.Error("expected the missing workspace not to be recreated")
+		}
+	})
+
+	testutils.RunTestWithName(t, "State Lock", func(t *testing.T) {
+		env := setupTest(t)
+
+		if err := createStructuredTask(env.rootDir, "locked", "build", "echo build"); err != nil {
+			t.Fatal(err)
+		}
+
+		stuck, err := state.AcquireLock(env.ctx, filepath.Join(env.rootDir, state.DefaultFileName), "apply", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stuck.Release()
+
+		err = executeCommand(env.ctx, "apply", "--auto-approve", "--lock-timeout", "200ms")
+		if err == nil || !strings.Contains(err.Error(), "force-unlock "+stuck.Info.ID) {
+			t.Fatalf("expected apply to be refused with the lock ID, got %v", err)
+		}
+
+		if err := executeCommand(env.ctx, "force-unlock", "not-the-id"); err == nil {
+			t.Error("expected force-unlock with the wrong ID to fail")
+		}
+		if err := executeCommand(env.ctx, "force-unlock", stuck.Info.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err != nil {
+			t.Fatalf("expected apply to run after force-unlock, got %v", err)
+		}
+		currentState, err := state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyWorkflowState(t, currentState, "locked", "completed")
+	})
+
+	testutils.RunTestWithName(t, "Incremental State", func(t *testing.T) {
+		env := setupTest(t)
+
+		// check only passes if prepare's completion is saved while the apply
+		// is still running
+		if err := createStructuredTask(env.rootDir, "ci", "prepare", "echo prepare"); err != nil {
+			t.Fatal(err)
+		}
+		if err := createStructuredTask(env.rootDir, "ci", "check", `for i in $(seq 50); do grep -q '"status": "completed"' tgfs-state.json && exit 0; sleep 0.1; done; exit 1`); err != nil {
+			t.Fatal(err)
+		}
+		if err := createDependencyLink(env.rootDir, "ci", "check", "prepare"); err != nil {
+			t.Fatal(err)
+		}
+		if err := createStructuredTask(env.rootDir, "ci", "lint", "exit 1"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err == nil {
+			t.Fatal("expected apply to fail with a failing task")
+		}
+		currentState, err := state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyWorkflowState(t, currentState, "ci", "failed")
+		verifyTaskState(t, currentState, "ci", "check", "completed")
+		verifyTaskState(t, currentState, "ci", "lint", "failed")
+
+		if err := createStructuredTask(env.rootDir, "docs", "build", "echo docs"); err != nil {
+			t.Fatal(err)
+		}
+		if err := createStructuredTask(env.rootDir, "ci", "lint", "echo lint"); err != nil {
+			t.Fatal(err)
+		}
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err != nil {
+			t.Fatal(err)
+		}
+		currentState, err = state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyWorkflowState(t, currentState, "ci", "completed")
+		verifyWorkflowState(t, currentState, "docs", "completed")
+		docs := *currentState.FindWorkflow("docs")
+
+		// docs has nothing to do and keeps the state of the apply that ran it
+		if err := createStructuredTask(env.rootDir, "ci", "lint", "echo lint again"); err != nil {
+			t.Fatal(err)
+		}
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err != nil {
+			t.Fatal(err)
+		}
+		currentState, err = state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		untouched := currentState.FindWorkflow("docs")
+		if untouched == nil || untouched.StartedAt == nil || !untouched.StartedAt.Equal(*docs.StartedAt) || untouched.Tasks[0].RunID != docs.Tasks[0].RunID {
+			t.Errorf("expected the untouched workflow to keep its previous state %+v, got %+v", docs, untouched)
+		}
+		if currentState.RunID == docs.Tasks[0].RunID {
+			t.Error("expected the third apply to get a run of its own")
+		}
+	})
+
+	testutils.RunTestWithName(t, "Apply Timeouts", func(t *testing.T) {
+		env := setupTest(t)
+
+		if err := createStructuredTask(env.rootDir, "train", "fit", "sleep 5"); err != nil {
+			t.Fatal(err)
+		}
+		if err := createStructuredTask(env.rootDir, "docs", "build", "echo docs"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(env.rootDir, "tgfs.yaml"), []byte("workflows:\n  train:\n    timeout: 300ms\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := executeCommand(env.ctx, "apply", "--auto-approve")
+		if err == nil || !strings.Contains(err.Error(), "workflow train timed out after 300ms") {
+			t.Fatalf("expected the train workflow to time out, got %v", err)
+		}
+		currentState, err := state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyWorkflowState(t, currentState, "train", "timeout")
+		verifyTaskState(t, currentState, "train", "fit", "timeout")
+		verifyWorkflowState(t, currentState, "docs", "completed")
+
+		if err := os.Remove(filepath.Join(env.rootDir, "tgfs.yaml")); err != nil {
+			t.Fatal(err)
+		}
+		err = executeCommand(env.ctx, "apply", "--auto-approve", "--timeout", "300ms")
+		if err == nil || !strings.Contains(err.Error(), "apply timed out after 300ms") {
+			t.Fatalf("expected the apply to time out, got %v", err)
+		}
+	})
+
+	testutils.RunTestWithName(t, "Max Running", func(t *testing.T) {
+		env := setupTest(t)
+
+		// Each task fails if another one holds the mutex directory
+		mutex := filepath.Join(env.rootDir, "running")
+		command := fmt.Sprintf("mkdir %s && sleep 0.1 && rmdir %s", mutex, mutex)
+		for _, w := range []string{"api", "web"} {
+			for _, task := range []string{"build", "test"} {
+				if err := createStructuredTask(env.rootDir, w, task, command); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		if err := executeCommand(env.ctx, "apply", "--auto-approve", "--max-running", "1"); err != nil {
+			t.Fatalf("expected tasks to run one at a time, got %v", err)
+		}
+		currentState, err := state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyWorkflowState(t, currentState, "api", "completed")
+		verifyWorkflowState(t, currentState, "web", "completed")
+
+		// The same cap can come from tgfs.yaml
+		command = fmt.Sprintf("mkdir %s && sleep 0.2 && rmdir %s", mutex, mutex)
+		for _, w := range []string{"api", "web"} {
+			for _, task := range []string{"build", "test"} {
+				if err := createStructuredTask(env.rootDir, w, task, command); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := os.WriteFile(filepath.Join(env.rootDir, "tgfs.yaml"), []byte("max_running: 1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err != nil {
+			t.Fatalf("expected tasks to run one at a time, got %v", err)
+		}
+		if err := os.Remove(filepath.Join(env.rootDir, "tgfs.yaml")); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	testutils.RunTestWithName(t, "Run History", func(t *testing.T) {
+		env := setupTest(t)
+
+		if err := createStructuredTask(env.rootDir, "release", "build", "echo build"); err != nil {
+			t.Fatal(err)
+		}
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err != nil {
+			t.Fatal(err)
+		}
+		first, err := state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := executeCommandOutput(env.ctx, "show", first.RunID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"Run " + first.RunID, "Status:   completed", "build: echo build", "build: completed"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("expected show output to contain %q, got:\n%s", want, out)
+			}
+		}
+
+		// Keep only the latest run; the first run is no longer referenced once
+		// its only task has run again
+		if err := os.WriteFile(filepath.Join(env.rootDir, "tgfs.yaml"), []byte("history:\n  keep: 1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := createStructuredTask(env.rootDir, "release", "build", "echo rebuild"); err != nil {
+			t.Fatal(err)
+		}
+		if err := executeCommand(env.ctx, "apply", "--auto-approve"); err != nil {
+			t.Fatal(err)
+		}
+		second, err := state.LoadState(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err = executeCommandOutput(env.ctx, "history")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, second.RunID) || strings.Contains(out, first.RunID) {
+			t.Errorf("expected history to list only run %s, got:\n%s", second.RunID, out)
+		}
+		if err := executeCommand(env.ctx, "show", first.RunID); err == nil {
+			t.Error("expected the pruned run to be gone")
+		}
+	})
+
+	testutils.RunTestWithName(t, "JSON Output", func(t *testing.T) {
+		env := setupTest(t)
+
+		if err := createStructuredTask(env.rootDir, "ci", "build", "echo build"); err != nil {
+			t.Fatal(err)
+		}
+		if err := createStructuredTask(env.rootDir, "ci", "lint", "exit 4"); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := executeCommandOutput(env.ctx, "plan", "--output", "json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var plan report.Plan
+		if err := json.Unmarshal([]byte(out), &plan); err != nil {
+			t.Fatalf("expected plan output to be a JSON document: %v\n%s", err, out)
+		}
+		if plan.SchemaVersion != report.SchemaVersion || !plan.HasChanges || plan.Summary.Tasks.Add != 2 || plan.Summary.Workflows.Add != 1 {
+			t.Errorf("unexpected plan document: %+v", plan)
+		}
+
+		out, err = executeCommandOutput(env.ctx, "apply", "--auto-approve", "--output", "json")
+		if err == nil {
+			t.Fatal("expected apply to fail with a failing task")
+		}
+
+		var events []report.Event
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			var event report.Event
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				t.Fatalf("expected every line to be a JSON event: %v\n%s", err, out)
+			}
+			events = append(events, event)
+		}
+
+		if len(events) == 0 || events[0].Type != report.EventPlan || events[0].Plan == nil {
+			t.Fatalf("expected the stream to start with the plan, got %s", out)
+		}
+		last := events[len(events)-1]
+		if last.Type != report.EventApplyFinished || last.Status != report.StatusFailed || last.Error == "" || last.RunID == "" {
+			t.Errorf("expected the stream to end with a failed apply_finished event, got %+v", last)
+		}
+
+		finished := make(map[string]report.Event)
+		for _, event := range events {
+			if event.SchemaVersion != report.SchemaVersion {
+				t.Errorf("expected schema version %d, got %+v", report.SchemaVersion, event)
+			}
+			if event.Type == "task_finished" {
+				finished[event.Task] = event
+			}
+		}
+		if build := finished["build"]; build.Status != "completed" || build.ExitCode == nil || *build.ExitCode != 0 || build.DurationMS == nil {
+			t.Errorf("unexpected build result: %+v", build)
+		}
+		if lint := finished["lint"]; lint.Status != "failed" || lint.ExitCode == nil || *lint.ExitCode != 4 || lint.Error == "" {
+			t.Errorf("unexpected lint result: %+v", lint)
+		}
+
+		if err := executeCommand(env.ctx, "apply", "--output", "json"); err == nil {
+			t.Error("expected --output json without --auto-approve to be refused")
+		}
+	})
+
 	testutils.RunTestWithName(t, "Concurrent Workflows", func(t *testing.T) {
 		env := setupTest(t)
 
@@ -234,13 +1088,29 @@ func executeCommand(ctx context.Context, args ...string) error {
 	cmd := cmd.NewRootCommand()
 	cmd.SetContext(ctx)
 	cmd.SetArgs(args)
+	return runCommand(ctx, cmd.Execute)
+}
+
+// executeCommandOutput executes a CLI command and returns what it wrote to its
+// configured output
+func executeCommandOutput(ctx context.Context, args ...string) (string, error) {
+	var out bytes.Buffer
+	cmd := cmd.NewRootCommand()
+	cmd.SetContext(ctx)
+	cmd.SetArgs(args)
+	cmd.SetOut(&out)
+	err := runCommand(ctx, cmd.Execute)
+	return out.String(), err
+}
+
+func runCommand(ctx context.Context, execute func() error) error {
 
 	// Create channel to track command completion
 	done := make(chan error, 1)
 
 	// Run command in goroutine
 	go func() {
-		done <- cmd.Execute()
+		done <- execute()
 		close(done)
 	}()
 
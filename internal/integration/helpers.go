@@ -27,7 +27,9 @@ func loadState(path string) (*state.StateFile, error) {
 	return &state, nil
 }
 
-// createTestWorkflow creates a test workflow with the given tasks
+// createTestWorkflow creates a test workflow with the given tasks. The tasks are
+// written as free-form prose so that their properties come from the mock
+// gopilot responses configured by each test.
 func createTestWorkflow(rootDir, workflowName string, tasks []string) error {
 	// Sanitize workflow name to match init behavior
 	workflowName = sanitizeWorkflowName(workflowName)
@@ -39,8 +41,32 @@ func createTestWorkflow(rootDir, workflowName string, tasks []string) error {
 	for _, task := range tasks {
 		content := []byte(fmt.Sprintf(`# %s
 
+Run the test command for %s and report whether it succeeded.
+`, task, task))
+
+		if err := os.WriteFile(
+			filepath.Join(workflowDir, task+".md"),
+			content,
+			0o644,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createStructuredTask writes a task file using the documented markdown sections
+func createStructuredTask(rootDir, workflowName, taskID, command string) error {
+	workflowDir := filepath.Join(rootDir, workflowName)
+	if err := os.MkdirAll(workflowDir, 0o755); err != nil {
+		return err
+	}
+
+	content := []byte(fmt.Sprintf(`# %s
+
 ## Command
-echo "test command"
+%s
 
 ## Dependencies
 None
@@ -49,22 +75,13 @@ None
 medium
 
 ## Retries
-1
+0
 
 ## Timeout
-30m
-`, task))
-
-		if err := os.WriteFile(
-			filepath.Join(workflowDir, task+".md"),
-			content,
-			0o644,
-		); err != nil {
-			return err
-		}
-	}
+1m
+`, taskID, command))
 
-	return nil
+	return os.WriteFile(filepath.Join(workflowDir, taskID+".md"), content, 0o644)
 }
 
 func createDependencyLink(rootDir, workflowName, taskID, dependencyID string) error {
@@ -0,0 +1,74 @@
+package orchestration
+
+import (
+	"context"
+	"sync"
+
+	"github.com/zackiles/task-graph-fs/internal/fsparse"
+)
+
+// Coordinator tracks task completion across all the workflows of an apply so
+// that dependencies between workflows can be enforced. Every orchestrator that
+// shares a coordinator reports its tasks' outcomes to it, and waits on it for
+// upstream tasks that live in another workflow.
+type Coordinator struct {
+	mu      sync.Mutex
+	done    map[string]chan struct{}
+	success map[string]bool
+}
+
+// NewCoordinator creates a coordinator that knows about every task of the
+// given workflows, identified by their qualified IDs
+func NewCoordinator(workflows []fsparse.Workflow) *Coordinator {
+	c := &Coordinator{
+		done:    make(map[string]chan struct{}),
+		success: make(map[string]bool),
+	}
+	for _, w := range workflows {
+		for _, t := range w.Tasks {
+			c.done[fsparse.QualifiedTaskID(w.Name, t.ID)] = make(chan struct{})
+		}
+	}
+	return c
+}
+
+// Finish records the outcome of a task and releases anything waiting on it.
+// Only the first outcome reported for a task counts.
+func (c *Coordinator) Finish(id string, success bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.done[id]
+	if !ok {
+		return
+	}
+	select {
+	case <-ch:
+		return
+	default:
+	}
+	c.success[id] = success
+	close(ch)
+}
+
+// Wait blocks until the given task has finished and reports whether it
+// completed successfully. Unknown tasks are reported as unsuccessful straight
+// away, since nothing will ever run them.
+func (c *Coordinator) Wait(ctx context.Context, id string) (bool, error) {
+	c.mu.Lock()
+	ch, ok := c.done[id]
+	c.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-ch:
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.success[id], nil
+}
@@ -0,0 +1,81 @@
+package orchestration
+
+import "time"
+
+// EventType identifies what an Event reports
+type EventType string
+
+const (
+	// EventApplyStarted is reported once per apply, before any task runs
+	EventApplyStarted EventType = "apply_started"
+	// EventTaskStarted is reported when a task's first attempt starts
+	EventTaskStarted EventType = "task_started"
+	// EventTaskRetrying is reported before each further attempt of a task;
+	// Attempt is the attempt about to start and ExitCode and Error describe
+	// the one that failed
+	EventTaskRetrying EventType = "task_retrying"
+	// EventTaskFinished is reported once per task that completed, failed,
+	// timed out or was skipped in this run. Tasks resumed from a previous run
+	// report nothing.
+	EventTaskFinished EventType = "task_finished"
+	// EventWorkflowFinished is reported once every task of a workflow finished
+	EventWorkflowFinished EventType = "workflow_finished"
+)
+
+// Event reports progress of an apply as it happens
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	RunID    string
+	Workflow string
+	Task     string
+	// Status is the final status of a finished task or workflow
+	Status string
+	// Attempt is the attempt number of a starting or retrying task, and the
+	// number of attempts made by a finished one
+	Attempt int
+	// ExitCode is the exit code of the task's last attempt, or -1 when the
+	// command could not be started or was terminated by a signal
+	ExitCode int
+	// Duration is how long a finished task or workflow took
+	Duration time.Duration
+	Error    string
+}
+
+// emit fills in the orchestrator's run and workflow and reports the event
+func (o *Orchestrator) emit(e Event) {
+	if o.onEvent == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	e.RunID = o.runID
+	e.Workflow = o.workflow.Name
+	o.onEvent(e)
+}
+
+// emitFinished reports a task that ran and reached its final status
+func (o *Orchestrator) emitFinished(id, status string, attempts, exitCode int, duration time.Duration, err error) {
+	e := Event{
+		Type:     EventTaskFinished,
+		Task:     id,
+		Status:   status,
+		Attempt:  attempts,
+		ExitCode: exitCode,
+		Duration: duration,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	o.emit(e)
+}
+
+// emitSkipped reports a task that was skipped without running
+func (o *Orchestrator) emitSkipped(id, reason string) {
+	o.emit(Event{
+		Type:     EventTaskFinished,
+		Task:     id,
+		Status:   "skipped",
+		ExitCode: -1,
+		Error:    reason,
+	})
+}
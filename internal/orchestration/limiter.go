@@ -0,0 +1,155 @@
+package orchestration
+
+import (
+	"context"
+	"sync"
+
+	"github.com/zackiles/task-graph-fs/internal/fsparse"
+)
+
+// rank orders runnable tasks: higher priority first, then the task that
+// heads the longest chain of dependents, since delaying it delays the most
+// work
+type rank struct {
+	priority int
+	// critical is the number of tasks on the longest dependency chain the
+	// task starts, itself included
+	critical int
+}
+
+// before reports whether a task of rank r should start before one of rank other
+func (r rank) before(other rank) bool {
+	if r.priority != other.priority {
+		return r.priority < other.priority
+	}
+	return r.critical > other.critical
+}
+
+// priorityOrder maps a task priority to its place in the ready queue. Tasks
+// without a known priority are treated as medium.
+func priorityOrder(priority string) int {
+	switch priority {
+	case "high":
+		return 0
+	case "low":
+		return 2
+	default:
+		return 1
+	}
+}
+
+// criticalPaths returns, for every task of the workflow, the number of tasks
+// on the longest chain of dependents within the workflow that it starts
+func criticalPaths(workflow *fsparse.Workflow, dependents map[string][]string) map[string]int {
+	lengths := make(map[string]int, len(workflow.Tasks))
+	visiting := make(map[string]bool)
+
+	var length func(id string) int
+	length = func(id string) int {
+		if n, ok := lengths[id]; ok {
+			return n
+		}
+		if visiting[id] {
+			// A cycle is reported when the workflow runs, count it once
+			return 0
+		}
+		visiting[id] = true
+		longest := 0
+		for _, next := range dependents[id] {
+			longest = max(longest, length(next))
+		}
+		visiting[id] = false
+		lengths[id] = longest + 1
+		return lengths[id]
+	}
+
+	for _, task := range workflow.Tasks {
+		length(task.ID)
+	}
+	return lengths
+}
+
+// Limiter caps how many tasks run at once across every orchestrator sharing
+// it. When all slots are taken, a freed slot goes to the highest ranked
+// waiting task of any workflow, and to the longest waiting one among equals.
+// A nil Limiter imposes no limit.
+type Limiter struct {
+	mu      sync.Mutex
+	free    int
+	waiters []*limitWaiter
+}
+
+type limitWaiter struct {
+	rank  rank
+	ready chan struct{}
+}
+
+// NewLimiter creates a limiter allowing n tasks to run at once. It returns
+// nil, meaning no limit, when n is below one.
+func NewLimiter(n int) *Limiter {
+	if n < 1 {
+		return nil
+	}
+	return &Limiter{free: n}
+}
+
+// acquire blocks until the task may run or ctx is done
+func (l *Limiter) acquire(ctx context.Context, r rank) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	if l.free > 0 {
+		l.free--
+		l.mu.Unlock()
+		return nil
+	}
+	w := &limitWaiter{rank: r, ready: make(chan struct{})}
+	l.waiters = append(l.waiters, w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	for i, other := range l.waiters {
+		if other == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			l.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	l.mu.Unlock()
+
+	// The slot was handed over just as ctx ended, pass it on
+	l.release()
+	return ctx.Err()
+}
+
+// release frees the slot of a task that finished
+func (l *Limiter) release() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.waiters) == 0 {
+		l.free++
+		return
+	}
+
+	best := 0
+	for i, w := range l.waiters[1:] {
+		if w.rank.before(l.waiters[best].rank) {
+			best = i + 1
+		}
+	}
+	w := l.waiters[best]
+	l.waiters = append(l.waiters[:best], l.waiters[best+1:]...)
+	close(w.ready)
+}
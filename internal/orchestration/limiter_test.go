@@ -0,0 +1,99 @@
+package orchestration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zackiles/task-graph-fs/internal/fsparse"
+)
+
+func TestLimiterAdmitsByRank(t *testing.T) {
+	limiter := NewLimiter(1)
+	ctx := context.Background()
+
+	if err := limiter.acquire(ctx, rank{priority: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue a low, a medium and a high priority task behind the running one
+	admitted := make(chan string, 3)
+	for i, w := range []struct {
+		name string
+		rank rank
+	}{
+		{"low", rank{priority: 2}},
+		{"medium", rank{priority: 1}},
+		{"high", rank{priority: 0}},
+	} {
+		go func(name string, r rank) {
+			if err := limiter.acquire(ctx, r); err != nil {
+				t.Error(err)
+				return
+			}
+			admitted <- name
+		}(w.name, w.rank)
+		waitForWaiters(t, limiter, i+1)
+	}
+
+	var order []string
+	for i := 0; i < 3; i++ {
+		limiter.release()
+		order = append(order, <-admitted)
+	}
+	if order[0] != "high" || order[1] != "medium" || order[2] != "low" {
+		t.Errorf("expected waiters to be admitted by priority, got %v", order)
+	}
+
+	// A waiter whose context ends gives up its place
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := limiter.acquire(ctx, rank{}); err == nil {
+		t.Error("expected acquire to fail once its context ended")
+	}
+	if n := countWaiters(limiter); n != 0 {
+		t.Errorf("expected no waiters left, got %d", n)
+	}
+
+	var unlimited *Limiter
+	if err := unlimited.acquire(context.Background(), rank{}); err != nil {
+		t.Errorf("expected a nil limiter to admit every task, got %v", err)
+	}
+	unlimited.release()
+}
+
+func TestCriticalPaths(t *testing.T) {
+	workflow := &fsparse.Workflow{
+		Tasks: []fsparse.Task{{ID: "fetch"}, {ID: "clean"}, {ID: "train"}, {ID: "lint"}},
+	}
+	dependents := map[string][]string{
+		"fetch": {"clean", "train"},
+		"clean": {"train"},
+	}
+
+	lengths := criticalPaths(workflow, dependents)
+	expected := map[string]int{"fetch": 3, "clean": 2, "train": 1, "lint": 1}
+	for id, want := range expected {
+		if lengths[id] != want {
+			t.Errorf("expected critical path of %s to be %d, got %d", id, want, lengths[id])
+		}
+	}
+}
+
+func countWaiters(l *Limiter) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.waiters)
+}
+
+// waitForWaiters waits until n tasks are queued on the limiter
+func waitForWaiters(t *testing.T, l *Limiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for countWaiters(l) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d waiters", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -2,114 +2,635 @@ package orchestration
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/zackiles/task-graph-fs/internal/fsparse"
 	"github.com/zackiles/task-graph-fs/internal/state"
+	"github.com/zackiles/task-graph-fs/internal/workspace"
 )
 
+// errAttemptTimedOut is the cause of an attempt stopped by the task's timeout
+var errAttemptTimedOut = errors.New("task attempt timed out")
+
 type Orchestrator struct {
-	workflow   *fsparse.Workflow
-	state      *state.WorkflowState
-	inProgress sync.Map
+	workflow    *fsparse.Workflow
+	state       *state.WorkflowState
+	retry       RetryPolicy
+	coordinator *Coordinator
+	limiter     *Limiter
+	maxRunning  int
+	runID       string
+	logDir      string
+	onEvent     func(Event)
+	inProgress  sync.Map
+
+	// stateMu guards the workflow state; onChange is called after each
+	// change to it
+	stateMu  sync.Locker
+	onChange func()
+
+	mu       sync.Mutex
+	failures []error
+}
+
+// Options configures optional orchestrator behaviour
+type Options struct {
+	// RetryPolicy controls the backoff between attempts of a failing task
+	RetryPolicy RetryPolicy
+	// Coordinator, when set, is shared by the orchestrators of every workflow in
+	// an apply so that cross-workflow dependencies are enforced
+	Coordinator *Coordinator
+	// Limiter, when set, is shared by the orchestrators of every workflow in
+	// an apply to cap how many tasks run at once across all of them
+	Limiter *Limiter
+	// MaxRunning caps how many of the workflow's tasks run at once; zero
+	// means no limit
+	MaxRunning int
+	// RunID identifies the run the tasks execute in and is recorded on each
+	// task that runs
+	RunID string
+	// LogDir is the run directory that task output is streamed to. Each task
+	// writes <LogDir>/<workflow>/<task>.log; when empty, only the bounded
+	// output tail kept in the task state is captured.
+	LogDir string
+	// OnEvent, when set, is called as tasks start, retry and finish. It is
+	// called from the goroutines running the tasks, so it must be safe for
+	// concurrent use.
+	OnEvent func(Event)
+	// StateLock, when set, guards the workflow state in place of the
+	// orchestrator's own lock, so that a state shared by several
+	// orchestrators can be read consistently while they run
+	StateLock sync.Locker
+	// OnStateChange, when set, is called after every change to the workflow
+	// state, without the state lock held, so that the state can be persisted
+	// as tasks start and finish
+	OnStateChange func()
+}
+
+// DefaultOptions returns the options used by NewOrchestrator
+func DefaultOptions() Options {
+	return Options{
+		RetryPolicy: DefaultRetryPolicy(),
+	}
 }
 
 func NewOrchestrator(workflow fsparse.Workflow, state *state.WorkflowState) *Orchestrator {
+	return NewOrchestratorWithOptions(workflow, state, DefaultOptions())
+}
+
+// NewOrchestratorWithOptions creates an orchestrator with explicit options
+func NewOrchestratorWithOptions(workflow fsparse.Workflow, state *state.WorkflowState, opts Options) *Orchestrator {
+	stateMu := opts.StateLock
+	if stateMu == nil {
+		stateMu = &sync.Mutex{}
+	}
 	return &Orchestrator{
-		workflow: &workflow,
-		state:    state,
+		workflow:    &workflow,
+		state:       state,
+		retry:       opts.RetryPolicy,
+		coordinator: opts.Coordinator,
+		limiter:     opts.Limiter,
+		maxRunning:  opts.MaxRunning,
+		runID:       opts.RunID,
+		logDir:      opts.LogDir,
+		onEvent:     opts.OnEvent,
+		stateMu:     stateMu,
+		onChange:    opts.OnStateChange,
 	}
 }
 
+// taskResult is sent by a task goroutine once the task has finished
+type taskResult struct {
+	id  string
+	err error
+}
+
+// externalResult is sent once an upstream task in another workflow finished
+type externalResult struct {
+	id  string
+	dep string
+	ok  bool
+}
+
+// Execute runs the workflow's tasks in dependency order. A task is started only
+// once every upstream task it depends on has completed; when a task fails, all
+// of its downstream tasks are marked as skipped. When more tasks are ready than
+// the MaxRunning and Limiter allow to run, the highest priority ones start
+// first, then those heading the longest chain of dependents. Upstream tasks in other
+// workflows are waited on through the orchestrator's Coordinator, and are
+// ignored when there is none. Tasks whose state is already "completed" are not
+// run again, which lets an interrupted apply resume.
+// Task failures are recorded in the workflow state and reported by Err, while
+// Execute itself only returns an error when the run could not be carried out
+// (cancellation or a cycle).
 func (o *Orchestrator) Execute(ctx context.Context) error {
 	// Create a new context with cancellation for task management
 	taskCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	// Create error channel for collecting task errors
-	errChan := make(chan error, len(o.workflow.Tasks))
-	var wg sync.WaitGroup
+	tasks := make(map[string]fsparse.Task, len(o.workflow.Tasks))
+	for _, task := range o.workflow.Tasks {
+		tasks[task.ID] = task
+	}
 
-	// Start task execution
+	// Build the edge lists. Local edges are tracked directly, cross-workflow
+	// edges (qualified IDs) are resolved through the coordinator.
+	pending := make(map[string]int, len(tasks))
+	dependents := make(map[string][]string)
+	external := make(map[string][]string)
+	for _, task := range o.workflow.Tasks {
+		pending[task.ID] = 0
+		for _, dep := range o.workflow.TaskDependencies(task.ID) {
+			if _, ok := tasks[dep]; ok && dep != task.ID {
+				pending[task.ID]++
+				dependents[dep] = append(dependents[dep], task.ID)
+				continue
+			}
+			if depWorkflow, _ := fsparse.SplitTaskID(dep); depWorkflow != "" && o.coordinator != nil {
+				pending[task.ID]++
+				external[task.ID] = append(external[task.ID], dep)
+			}
+		}
+	}
+
+	critical := criticalPaths(o.workflow, dependents)
+	ranks := make(map[string]rank, len(tasks))
+	order := make(map[string]int, len(tasks))
 	for i, task := range o.workflow.Tasks {
+		ranks[task.ID] = rank{priority: priorityOrder(task.Priority), critical: critical[task.ID]}
+		order[task.ID] = i
+	}
+
+	results := make(chan taskResult, len(tasks))
+	var wg sync.WaitGroup
+	finished := make(map[string]bool, len(tasks))
+	var ready []string
+	running := 0
+	waiting := 0
+
+	// finish records a task's outcome locally and for other workflows
+	finish := func(id string, success bool) {
+		finished[id] = true
+		if o.coordinator != nil {
+			o.coordinator.Finish(fsparse.QualifiedTaskID(o.workflow.Name, id), success)
+		}
+	}
+
+	// Make sure nothing in another workflow keeps waiting on tasks that never
+	// got to run because this workflow stopped early
+	defer func() {
+		for _, task := range o.workflow.Tasks {
+			if !finished[task.ID] {
+				finish(task.ID, false)
+			}
+		}
+	}()
+
+	start := func(t fsparse.Task) {
+		wg.Add(1)
+		running++
+		o.inProgress.Store(t.ID, struct{}{})
+		go func() {
+			defer wg.Done()
+			defer o.inProgress.Delete(t.ID)
+			// Wait for a slot shared with the other workflows
+			if err := o.limiter.acquire(taskCtx, ranks[t.ID]); err != nil {
+				results <- taskResult{id: t.ID, err: err}
+				return
+			}
+			defer o.limiter.release()
+			results <- taskResult{id: t.ID, err: o.executeTask(taskCtx, t)}
+		}()
+	}
+
+	// enqueue marks a task as ready to run; dispatch starts the best ready
+	// tasks while the workflow's cap on running tasks allows
+	enqueue := func(id string) {
+		ready = append(ready, id)
+	}
+	dispatch := func() {
+		for len(ready) > 0 && (o.maxRunning < 1 || running < o.maxRunning) {
+			best := 0
+			for i, id := range ready[1:] {
+				r, b := ranks[id], ranks[ready[best]]
+				if r.before(b) || (r == b && order[id] < order[ready[best]]) {
+					best = i + 1
+				}
+			}
+			id := ready[best]
+			ready = append(ready[:best], ready[best+1:]...)
+			if !finished[id] {
+				start(tasks[id])
+			}
+		}
+	}
+
+	// Tasks completed by a previous run count as already finished
+	for _, task := range o.workflow.Tasks {
+		if o.taskStatus(task.ID) != "completed" {
+			continue
+		}
+		finish(task.ID, true)
+		for _, next := range dependents[task.ID] {
+			pending[next]--
+		}
+	}
+
+	// Wait on upstream tasks in other workflows in the background
+	var externalCount int
+	for _, deps := range external {
+		externalCount += len(deps)
+	}
+	externals := make(chan externalResult, externalCount)
+	for _, task := range o.workflow.Tasks {
+		if finished[task.ID] {
+			continue
+		}
+		for _, dep := range external[task.ID] {
+			waiting++
+			go func(id, dep string) {
+				ok, err := o.coordinator.Wait(taskCtx, dep)
+				if err != nil {
+					return
+				}
+				externals <- externalResult{id: id, dep: dep, ok: ok}
+			}(task.ID, dep)
+		}
+	}
+
+	// Start every task without outstanding dependencies
+	for _, task := range o.workflow.Tasks {
+		if pending[task.ID] == 0 && !finished[task.ID] {
+			enqueue(task.ID)
+		}
+	}
+	dispatch()
+
+	for len(finished) < len(tasks) {
+		if running == 0 && waiting == 0 && len(ready) == 0 {
+			// Nothing is running and nothing can start: the rest form a cycle
+			var blocked []string
+			for _, task := range o.workflow.Tasks {
+				if !finished[task.ID] {
+					blocked = append(blocked, task.ID)
+					o.skipTask(task.ID, "dependency cycle detected")
+				}
+			}
+			return fmt.Errorf("dependency cycle detected between tasks: %s", strings.Join(blocked, ", "))
+		}
+
 		select {
 		case <-taskCtx.Done():
+			wg.Wait()
 			return taskCtx.Err()
-		default:
-			wg.Add(1)
-			o.inProgress.Store(task.ID, struct{}{})
-			go func(taskIndex int, t fsparse.Task) {
-				defer wg.Done()
-				defer o.inProgress.Delete(t.ID)
-
-				if err := o.executeTask(taskCtx, t); err != nil {
-					errChan <- fmt.Errorf("task %s failed: %w", t.ID, err)
-					cancel() // Now cancel() is defined
+		case r := <-externals:
+			waiting--
+			if finished[r.id] {
+				continue
+			}
+			if !r.ok {
+				reason := fmt.Sprintf("upstream task %s did not complete", r.dep)
+				o.recordFailure(fmt.Errorf("task %s skipped: %s", r.id, reason))
+				o.skipTask(r.id, reason)
+				finish(r.id, false)
+				o.skipDownstream(r.id, dependents, finished, finish)
+				continue
+			}
+			pending[r.id]--
+			if pending[r.id] == 0 {
+				enqueue(r.id)
+				dispatch()
+			}
+		case r := <-results:
+			running--
+			finish(r.id, r.err == nil)
+
+			if r.err != nil {
+				o.recordFailure(fmt.Errorf("task %s failed: %w", r.id, r.err))
+				o.skipDownstream(r.id, dependents, finished, finish)
+				dispatch()
+				continue
+			}
+
+			for _, next := range dependents[r.id] {
+				pending[next]--
+				if pending[next] == 0 && !finished[next] {
+					enqueue(next)
 				}
-			}(i, task)
+			}
+			dispatch()
 		}
 	}
 
-	// Wait for all tasks to complete or context to be cancelled
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
+	wg.Wait()
+	return nil
+}
 
-	select {
-	case <-taskCtx.Done():
-		return taskCtx.Err()
-	case err := <-errChan:
-		return err
-	case <-done:
-		return nil
+// Err returns the combined errors of every task that failed during Execute, or
+// nil when all tasks that ran completed successfully.
+func (o *Orchestrator) Err() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return errors.Join(o.failures...)
+}
+
+func (o *Orchestrator) recordFailure(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.failures = append(o.failures, err)
+}
+
+// skipDownstream marks every task reachable from id as skipped and finished
+func (o *Orchestrator) skipDownstream(id string, dependents map[string][]string, finished map[string]bool, finish func(string, bool)) {
+	queue := append([]string(nil), dependents[id]...)
+	sort.Strings(queue)
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if finished[next] {
+			continue
+		}
+		finish(next, false)
+		o.skipTask(next, fmt.Sprintf("upstream task %s did not complete", id))
+		queue = append(queue, dependents[next]...)
 	}
 }
 
-func (o *Orchestrator) executeTask(ctx context.Context, task fsparse.Task) error {
-	// Update task status
+func (o *Orchestrator) taskStatus(id string) string {
+	o.stateMu.Lock()
+	defer o.stateMu.Unlock()
+	for _, ts := range o.state.Tasks {
+		if ts.ID == id {
+			return ts.Status
+		}
+	}
+	return ""
+}
+
+func (o *Orchestrator) setTaskStatus(id, status string) {
+	o.updateTask(id, func(ts *state.TaskState) {
+		ts.Status = status
+	})
+}
+
+// finishTask records the final status, timing and outcome of a task that ran
+// and reports it
+func (o *Orchestrator) finishTask(id, status string, attempts, exitCode int, started time.Time, err error) {
+	duration := time.Since(started)
+	endedAt := time.Now().UTC()
+	o.updateTask(id, func(ts *state.TaskState) {
+		ts.Status = status
+		ts.EndedAt = &endedAt
+		ts.Duration = state.FormatDuration(duration)
+		ts.AttemptCount = attempts
+		ts.ExitCode = &exitCode
+		ts.Error = ""
+		if err != nil {
+			ts.Error = err.Error()
+		}
+	})
+	o.emitFinished(id, status, attempts, exitCode, duration, err)
+}
+
+// skipTask marks a task that won't run as skipped and reports why
+func (o *Orchestrator) skipTask(id, reason string) {
+	o.updateTask(id, func(ts *state.TaskState) {
+		ts.Status = "skipped"
+		ts.Error = reason
+	})
+	o.emitSkipped(id, reason)
+}
+
+// updateTask applies fn to the state of the given task while holding the
+// state lock, then reports the change
+func (o *Orchestrator) updateTask(id string, fn func(*state.TaskState)) {
+	o.stateMu.Lock()
 	for i := range o.state.Tasks {
-		if o.state.Tasks[i].ID == task.ID {
-			o.state.Tasks[i].Status = "running"
+		if o.state.Tasks[i].ID == id {
+			fn(&o.state.Tasks[i])
 			break
 		}
 	}
+	o.stateMu.Unlock()
 
-	// Parse the timeout duration from the task
-	timeout, err := time.ParseDuration(task.Timeout)
-	if err != nil {
-		timeout = 30 * time.Second // fallback to default timeout
+	if o.onChange != nil {
+		o.onChange()
 	}
+}
 
-	// Create command with task-specific timeout context
-	taskCtx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+// executeTask runs the task's command, retrying failed attempts with backoff
+// until the task succeeds or its retries are exhausted.
+func (o *Orchestrator) executeTask(ctx context.Context, task fsparse.Task) error {
+	// Update task status
+	started := time.Now()
+	startedAt := started.UTC()
+	o.updateTask(task.ID, func(ts *state.TaskState) {
+		ts.Status = "running"
+		ts.RunID = o.runID
+		ts.StartedAt = &startedAt
+	})
+	o.emit(Event{Type: EventTaskStarted, Task: task.ID, Attempt: 1})
 
-	cmd := exec.CommandContext(taskCtx, "sh", "-c", task.Command)
+	timeout, err := taskTimeout(task)
+	if err != nil {
+		o.finishTask(task.ID, "failed", 0, -1, started, err)
+		return err
+	}
 
-	// Run command
-	err = cmd.Run()
+	attempts := task.Retries + 1
+	if attempts < 1 {
+		attempts = 1
+	}
 
-	// Update task status based on result
-	for i := range o.state.Tasks {
-		if o.state.Tasks[i].ID == task.ID {
-			if err != nil {
-				if taskCtx.Err() == context.DeadlineExceeded {
-					o.state.Tasks[i].Status = "timeout"
-					err = fmt.Errorf("task %s timed out after %s: %w", task.ID, timeout, err)
-				} else {
-					o.state.Tasks[i].Status = "failed"
-				}
-			} else {
-				o.state.Tasks[i].Status = "completed"
+	logFile, err := o.openTaskLog(task)
+	if err != nil {
+		o.finishTask(task.ID, "failed", 0, -1, started, err)
+		return err
+	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+
+	var (
+		last     state.AttemptState
+		timedOut bool
+	)
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			if waitErr := sleepContext(ctx, o.retry.Backoff(attempt-1)); waitErr != nil {
+				break
 			}
+			o.emit(Event{
+				Type:     EventTaskRetrying,
+				Task:     task.ID,
+				Attempt:  attempt,
+				ExitCode: last.ExitCode,
+				Error:    last.Error,
+			})
+		}
+
+		last, timedOut, err = o.runAttempt(ctx, task, attempt, timeout, logFile)
+		if err == nil || ctx.Err() != nil {
 			break
 		}
 	}
 
+	// Update task status based on the final attempt
+	status := "completed"
+	switch {
+	case err == nil:
+	case timedOut:
+		status = "timeout"
+		err = fmt.Errorf("task %s timed out after %s: %w", task.ID, timeout, err)
+	case errors.Is(ctx.Err(), context.Canceled):
+		// The apply was interrupted, the task itself didn't fail
+		status = "cancelled"
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		// The workflow or the apply ran out of time
+		status = "timeout"
+		err = fmt.Errorf("task %s stopped: %w", task.ID, context.Cause(ctx))
+	default:
+		status = "failed"
+	}
+	o.finishTask(task.ID, status, last.Number, last.ExitCode, started, err)
+
 	return err
 }
+
+// runAttempt executes a single attempt of the task and records it in the state
+func (o *Orchestrator) runAttempt(ctx context.Context, task fsparse.Task, number int, timeout time.Duration, logFile *os.File) (state.AttemptState, bool, error) {
+	// Create command with task-specific timeout context; a task without a
+	// timeout runs until it finishes or the apply stops it
+	taskCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		taskCtx, cancel = context.WithTimeoutCause(ctx, timeout, errAttemptTimedOut)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(taskCtx, "sh", "-c", task.Command)
+	killProcessGroupOnCancel(cmd)
+	// Don't hang on background processes that keep the output pipes open
+	cmd.WaitDelay = time.Second
+	cmd.Dir = taskWorkdir(task)
+	if len(task.Env) > 0 {
+		cmd.Env = append(os.Environ(), taskEnv(task)...)
+	}
+
+	attempt := state.AttemptState{
+		Number:    number,
+		StartedAt: time.Now().UTC(),
+	}
+
+	// Stream stdout and stderr to the log file while keeping a bounded tail
+	tail := newTailBuffer(maxOutputTail)
+	var output io.Writer = tail
+	if logFile != nil {
+		fmt.Fprintf(logFile, "=== attempt %d started %s ===\n", number, attempt.StartedAt.Format(time.RFC3339))
+		output = io.MultiWriter(logFile, tail)
+	}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	// Run command
+	err := cmd.Run()
+
+	attempt.EndedAt = time.Now().UTC()
+	attempt.ExitCode = exitCode(cmd, err)
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	if logFile != nil {
+		fmt.Fprintf(logFile, "=== attempt %d exited with code %d ===\n", number, attempt.ExitCode)
+	}
+
+	o.updateTask(task.ID, func(ts *state.TaskState) {
+		ts.Attempts = append(ts.Attempts, attempt)
+		ts.Output = tail.String()
+	})
+
+	// Only the task's own deadline makes it time out; a deadline of the
+	// workflow or apply is reported by the caller
+	return attempt, errors.Is(context.Cause(taskCtx), errAttemptTimedOut), err
+}
+
+// taskTimeout returns how long each attempt of a task may run, zero meaning
+// no limit
+func taskTimeout(task fsparse.Task) (time.Duration, error) {
+	if task.Timeout == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(task.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("task %s has invalid timeout %q, expected a duration such as 30m", task.ID, task.Timeout)
+	}
+	return timeout, nil
+}
+
+// openTaskLog creates the task's log file inside the run directory and records
+// its location in the task state. It returns nil when logging is disabled.
+func (o *Orchestrator) openTaskLog(task fsparse.Task) (*os.File, error) {
+	if o.logDir == "" {
+		return nil, nil
+	}
+
+	logPath := workspace.TaskLogPath(o.workflow.Name, task.ID)
+	fullPath := filepath.Join(o.logDir, logPath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory for task %s: %w", task.ID, err)
+	}
+
+	logFile, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file for task %s: %w", task.ID, err)
+	}
+
+	o.updateTask(task.ID, func(ts *state.TaskState) {
+		ts.LogPath = filepath.ToSlash(logPath)
+	})
+
+	return logFile, nil
+}
+
+// taskWorkdir returns the directory a task runs in. A relative workdir is
+// resolved against the directory holding the task file; without one the task
+// runs in the current directory.
+func taskWorkdir(task fsparse.Task) string {
+	if task.Workdir == "" || filepath.IsAbs(task.Workdir) {
+		return task.Workdir
+	}
+	return filepath.Join(filepath.Dir(task.MarkdownPath), task.Workdir)
+}
+
+// taskEnv returns the task's extra environment variables as sorted KEY=VALUE
+// pairs
+func taskEnv(task fsparse.Task) []string {
+	env := make([]string, 0, len(task.Env))
+	for key, value := range task.Env {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// exitCode returns the exit code of a finished command, or -1 when the command
+// could not be started or was terminated by a signal.
+func exitCode(cmd *exec.Cmd, err error) int {
+	if cmd.ProcessState != nil {
+		return cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
@@ -2,6 +2,11 @@ package orchestration
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -102,3 +107,536 @@ func TestOrchestratorFailure(t *testing.T) {
 		t.Errorf("expected task status 'failed', got '%s'", workflowState.Tasks[0].Status)
 	}
 }
+
+func TestOrchestratorDependencyOrder(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "fetched")
+
+	workflow := fsparse.Workflow{
+		Name: "pipeline",
+		Tasks: []fsparse.Task{
+			{
+				ID:      "clean-data",
+				Command: "test -f " + marker,
+				Timeout: "1m",
+			},
+			{
+				ID:      "fetch-data",
+				Command: "sleep 0.2 && touch " + marker,
+				Timeout: "1m",
+			},
+		},
+		Dependencies: map[string][]string{
+			"clean-data": {"fetch-data"},
+		},
+	}
+
+	workflowState := &state.WorkflowState{
+		WorkflowID: "pipeline",
+		Tasks: []state.TaskState{
+			{ID: "clean-data", Status: "pending"},
+			{ID: "fetch-data", Status: "pending"},
+		},
+	}
+
+	orchestrator := NewOrchestrator(workflow, workflowState)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := orchestrator.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := orchestrator.Err(); err != nil {
+		t.Fatalf("expected no task failures, got %v", err)
+	}
+
+	for _, task := range workflowState.Tasks {
+		if task.Status != "completed" {
+			t.Errorf("expected %s status 'completed', got '%s'", task.ID, task.Status)
+		}
+	}
+}
+
+func TestOrchestratorSkipsDownstreamOfFailure(t *testing.T) {
+	workflow := fsparse.Workflow{
+		Name: "pipeline",
+		Tasks: []fsparse.Task{
+			{ID: "fetch", Command: "exit 1", Timeout: "1m"},
+			{ID: "clean", Command: "echo clean", Timeout: "1m"},
+			{ID: "transform", Command: "echo transform", Timeout: "1m"},
+			{ID: "unrelated", Command: "echo unrelated", Timeout: "1m"},
+		},
+		Dependencies: map[string][]string{
+			"clean":     {"fetch"},
+			"transform": {"clean"},
+		},
+	}
+
+	workflowState := &state.WorkflowState{
+		WorkflowID: "pipeline",
+		Tasks: []state.TaskState{
+			{ID: "fetch", Status: "pending"},
+			{ID: "clean", Status: "pending"},
+			{ID: "transform", Status: "pending"},
+			{ID: "unrelated", Status: "pending"},
+		},
+	}
+
+	orchestrator := NewOrchestrator(workflow, workflowState)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := orchestrator.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := orchestrator.Err(); err == nil {
+		t.Fatal("expected a task failure to be reported")
+	}
+
+	expected := map[string]string{
+		"fetch":     "failed",
+		"clean":     "skipped",
+		"transform": "skipped",
+		"unrelated": "completed",
+	}
+	for _, task := range workflowState.Tasks {
+		if task.Status != expected[task.ID] {
+			t.Errorf("expected %s status '%s', got '%s'", task.ID, expected[task.ID], task.Status)
+		}
+	}
+
+	fetch := workflowState.Tasks[0]
+	if fetch.ExitCode == nil || *fetch.ExitCode != 1 || fetch.Error == "" {
+		t.Errorf("expected the failed task to record its exit code and error, got %+v", fetch)
+	}
+	if clean := workflowState.Tasks[1]; !strings.Contains(clean.Error, "fetch") || clean.Duration != "" {
+		t.Errorf("expected the skipped task to record why it was skipped, got %+v", clean)
+	}
+}
+
+func TestOrchestratorRetries(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "attempts")
+
+	workflow := fsparse.Workflow{
+		Name: "flaky",
+		Tasks: []fsparse.Task{
+			{
+				ID: "fetch",
+				// Fails on the first two attempts and succeeds on the third
+				Command: "echo x >> " + counter + " && test $(wc -l < " + counter + ") -ge 3",
+				Timeout: "1m",
+				Retries: 3,
+			},
+		},
+	}
+
+	workflowState := &state.WorkflowState{
+		WorkflowID: "flaky",
+		Tasks: []state.TaskState{
+			{ID: "fetch", Status: "pending"},
+		},
+	}
+
+	orchestrator := NewOrchestratorWithOptions(workflow, workflowState, Options{
+		RetryPolicy: RetryPolicy{InitialBackoff: 10 * time.Millisecond, Multiplier: 2},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := orchestrator.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := orchestrator.Err(); err != nil {
+		t.Fatalf("expected task to succeed after retries, got %v", err)
+	}
+
+	task := workflowState.Tasks[0]
+	if task.Status != "completed" {
+		t.Errorf("expected task status 'completed', got '%s'", task.Status)
+	}
+	if len(task.Attempts) != 3 {
+		t.Fatalf("expected 3 recorded attempts, got %d", len(task.Attempts))
+	}
+	if task.Attempts[0].ExitCode != 1 || task.Attempts[0].Error == "" {
+		t.Errorf("expected first attempt to record exit code 1 and an error, got %+v", task.Attempts[0])
+	}
+	if task.Attempts[2].ExitCode != 0 || task.Attempts[2].Error != "" {
+		t.Errorf("expected final attempt to succeed, got %+v", task.Attempts[2])
+	}
+	if task.AttemptCount != 3 || task.ExitCode == nil || *task.ExitCode != 0 || task.Error != "" {
+		t.Errorf("expected the task to record 3 attempts and a final exit code of 0, got %+v", task)
+	}
+	if task.StartedAt == nil || task.EndedAt == nil || task.EndedAt.Before(*task.StartedAt) || task.Duration == "" {
+		t.Errorf("expected the task to record its timing, got %+v", task)
+	}
+}
+
+func TestOrchestratorEvents(t *testing.T) {
+	workflow := fsparse.Workflow{
+		Name: "pipeline",
+		Tasks: []fsparse.Task{
+			{ID: "fetch", Command: "exit 3", Timeout: "1m", Retries: 1},
+			{ID: "clean", Command: "echo clean", Timeout: "1m"},
+		},
+		Dependencies: map[string][]string{
+			"clean": {"fetch"},
+		},
+	}
+
+	workflowState := &state.WorkflowState{
+		WorkflowID: "pipeline",
+		Tasks: []state.TaskState{
+			{ID: "fetch", Status: "pending"},
+			{ID: "clean", Status: "pending"},
+		},
+	}
+
+	var (
+		mu     sync.Mutex
+		events []Event
+	)
+	orchestrator := NewOrchestratorWithOptions(workflow, workflowState, Options{
+		RetryPolicy: RetryPolicy{InitialBackoff: 10 * time.Millisecond, Multiplier: 2},
+		RunID:       "run-1",
+		OnEvent: func(e Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := orchestrator.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Event{
+		{Type: EventTaskStarted, Task: "fetch", Attempt: 1},
+		{Type: EventTaskRetrying, Task: "fetch", Attempt: 2, ExitCode: 3},
+		{Type: EventTaskFinished, Task: "fetch", Status: "failed", Attempt: 2, ExitCode: 3},
+		{Type: EventTaskFinished, Task: "clean", Status: "skipped", ExitCode: -1},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %+v", len(expected), events)
+	}
+	for i, want := range expected {
+		got := events[i]
+		if got.Type != want.Type || got.Task != want.Task || got.Status != want.Status || got.Attempt != want.Attempt || got.ExitCode != want.ExitCode {
+			t.Errorf("event %d: expected %+v, got %+v", i, want, got)
+		}
+		if got.RunID != "run-1" || got.Workflow != "pipeline" || got.Time.IsZero() {
+			t.Errorf("event %d: expected run, workflow and time to be set, got %+v", i, got)
+		}
+	}
+	if events[2].Error == "" || events[2].Duration <= 0 {
+		t.Errorf("expected the failed task to report its error and duration, got %+v", events[2])
+	}
+	if !strings.Contains(events[3].Error, "fetch") {
+		t.Errorf("expected the skipped task to name its upstream task, got %q", events[3].Error)
+	}
+}
+
+func TestOrchestratorStateChanges(t *testing.T) {
+	workflow := fsparse.Workflow{
+		Name: "pipeline",
+		Tasks: []fsparse.Task{
+			{ID: "build", Command: "echo build", Timeout: "1m"},
+		},
+	}
+
+	workflowState := &state.WorkflowState{
+		WorkflowID: "pipeline",
+		Tasks:      []state.TaskState{{ID: "build", Status: "pending"}},
+	}
+
+	// Every change is observed under the shared state lock, as a recorder
+	// saving the state would
+	var (
+		mu       sync.Mutex
+		statuses []string
+	)
+	orchestrator := NewOrchestratorWithOptions(workflow, workflowState, Options{
+		StateLock: &mu,
+		OnStateChange: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			status := workflowState.Tasks[0].Status
+			if len(statuses) == 0 || statuses[len(statuses)-1] != status {
+				statuses = append(statuses, status)
+			}
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := orchestrator.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(statuses, ",") != "running,completed" {
+		t.Errorf("expected the running and completed transitions to be reported, got %v", statuses)
+	}
+}
+
+func TestOrchestratorCancelled(t *testing.T) {
+	workflow := fsparse.Workflow{
+		Name: "pipeline",
+		Tasks: []fsparse.Task{
+			{ID: "slow", Command: "sleep 5", Timeout: "1m"},
+			{ID: "after", Command: "echo after", Timeout: "1m"},
+		},
+		Dependencies: map[string][]string{
+			"after": {"slow"},
+		},
+	}
+
+	workflowState := &state.WorkflowState{
+		WorkflowID: "pipeline",
+		Tasks: []state.TaskState{
+			{ID: "slow", Status: "pending"},
+			{ID: "after", Status: "pending"},
+		},
+	}
+
+	orchestrator := NewOrchestrator(workflow, workflowState)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	if err := orchestrator.Execute(ctx); err != context.Canceled {
+		t.Fatalf("expected Execute to report the cancellation, got %v", err)
+	}
+
+	if status := workflowState.Tasks[0].Status; status != "cancelled" {
+		t.Errorf("expected the interrupted task to be cancelled, got %s", status)
+	}
+	if status := workflowState.Tasks[1].Status; status != "pending" {
+		t.Errorf("expected the task that never started to stay pending, got %s", status)
+	}
+}
+
+func TestOrchestratorTimeouts(t *testing.T) {
+	workflow := fsparse.Workflow{
+		Name: "training",
+		Tasks: []fsparse.Task{
+			{ID: "quick", Command: "sleep 5", Timeout: "100ms"},
+			{ID: "long", Command: "sleep 5"},
+		},
+	}
+
+	workflowState := &state.WorkflowState{
+		WorkflowID: "training",
+		Tasks: []state.TaskState{
+			{ID: "quick", Status: "pending"},
+			{ID: "long", Status: "pending"},
+		},
+	}
+
+	orchestrator := NewOrchestrator(workflow, workflowState)
+
+	// The workflow's deadline stops the task that has no timeout of its own
+	cause := errors.New("workflow training timed out")
+	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Second, cause)
+	defer cancel()
+
+	if err := orchestrator.Execute(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected Execute to report the deadline, got %v", err)
+	}
+
+	quick, long := workflowState.Tasks[0], workflowState.Tasks[1]
+	if quick.Status != "timeout" || !strings.Contains(quick.Error, "timed out after 100ms") {
+		t.Errorf("expected quick to hit its own timeout, got %s: %s", quick.Status, quick.Error)
+	}
+	if long.Status != "timeout" || !strings.Contains(long.Error, cause.Error()) {
+		t.Errorf("expected long to be stopped by the workflow deadline, got %s: %s", long.Status, long.Error)
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	tests := []struct {
+		timeout string
+		want    time.Duration
+		wantErr bool
+	}{
+		{timeout: "", want: 0},
+		{timeout: "6h", want: 6 * time.Hour},
+		{timeout: "soon", wantErr: true},
+		{timeout: "0s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := taskTimeout(fsparse.Task{ID: "train", Timeout: tt.timeout})
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("taskTimeout(%q) = %v, %v; expected %v, error %v", tt.timeout, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestOrchestratorReadyQueue(t *testing.T) {
+	workflow := fsparse.Workflow{
+		Name: "build",
+		Tasks: []fsparse.Task{
+			{ID: "docs", Command: "true", Timeout: "1m", Priority: "low"},
+			{ID: "lint", Command: "true", Timeout: "1m", Priority: "medium"},
+			{ID: "fetch", Command: "true", Timeout: "1m", Priority: "medium"},
+			{ID: "compile", Command: "true", Timeout: "1m", Priority: "medium"},
+			{ID: "release", Command: "true", Timeout: "1m", Priority: "high"},
+		},
+		Dependencies: map[string][]string{
+			"compile": {"fetch"},
+		},
+	}
+
+	workflowState := &state.WorkflowState{WorkflowID: "build"}
+	for _, task := range workflow.Tasks {
+		workflowState.Tasks = append(workflowState.Tasks, state.TaskState{ID: task.ID, Status: "pending"})
+	}
+
+	var (
+		mu      sync.Mutex
+		started []string
+	)
+	orchestrator := NewOrchestratorWithOptions(workflow, workflowState, Options{
+		MaxRunning: 1,
+		OnEvent: func(e Event) {
+			if e.Type != EventTaskStarted {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			started = append(started, e.Task)
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := orchestrator.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// High priority first, then fetch ahead of lint since compile waits on
+	// it; lint and compile tie and keep workflow order
+	expected := "release,fetch,lint,compile,docs"
+	if got := strings.Join(started, ","); got != expected {
+		t.Errorf("expected tasks to start in order %s, got %s", expected, got)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     300 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}
+	for i, want := range expected {
+		if got := policy.Backoff(i + 1); got != want {
+			t.Errorf("retry %d: expected backoff %v, got %v", i+1, want, got)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		got := policy.Backoff(1)
+		if got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("expected jittered backoff within 50ms-150ms, got %v", got)
+		}
+	}
+}
+
+func TestOrchestratorCapturesOutput(t *testing.T) {
+	logDir := t.TempDir()
+
+	workflow := fsparse.Workflow{
+		Name: "nested/logs",
+		Tasks: []fsparse.Task{
+			{
+				ID:      "noisy",
+				Command: "echo to-stdout && echo to-stderr >&2",
+				Timeout: "1m",
+			},
+		},
+	}
+
+	workflowState := &state.WorkflowState{
+		WorkflowID: "nested/logs",
+		Tasks: []state.TaskState{
+			{ID: "noisy", Status: "pending"},
+		},
+	}
+
+	opts := DefaultOptions()
+	opts.LogDir = logDir
+	orchestrator := NewOrchestratorWithOptions(workflow, workflowState, opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := orchestrator.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	task := workflowState.Tasks[0]
+	if task.Output != "to-stdout\nto-stderr\n" {
+		t.Errorf("expected captured output tail, got %q", task.Output)
+	}
+	if task.LogPath != "nested/logs/noisy.log" {
+		t.Errorf("expected log path 'nested/logs/noisy.log', got '%s'", task.LogPath)
+	}
+
+	data, err := os.ReadFile(filepath.Join(logDir, "nested", "logs", "noisy.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "to-stdout\nto-stderr\n") {
+		t.Errorf("expected log file to contain task output, got %q", data)
+	}
+}
+
+func TestOrchestratorTaskEnvAndWorkdir(t *testing.T) {
+	workflowDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(workflowDir, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	workflow := fsparse.Workflow{
+		Name: "workflow1",
+		Tasks: []fsparse.Task{
+			{
+				ID:           "build",
+				MarkdownPath: filepath.Join(workflowDir, "build.md"),
+				Command:      `echo "$TARGET" && basename "$PWD"`,
+				Timeout:      "1m",
+				Env:          map[string]string{"TARGET": "release"},
+				Workdir:      "src",
+			},
+		},
+	}
+
+	workflowState := &state.WorkflowState{
+		WorkflowID: "workflow1",
+		Tasks: []state.TaskState{
+			{ID: "build", Status: "pending"},
+		},
+	}
+
+	orchestrator := NewOrchestrator(workflow, workflowState)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := orchestrator.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if output := workflowState.Tasks[0].Output; output != "release\nsrc\n" {
+		t.Errorf("expected task env and workdir to apply, got %q", output)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package orchestration
+
+import "os/exec"
+
+// killProcessGroupOnCancel leaves cancelling to exec.CommandContext, which
+// kills the command's own process. Without process groups, processes it
+// started may keep running.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
@@ -0,0 +1,73 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/zackiles/task-graph-fs/internal/fsparse"
+	"github.com/zackiles/task-graph-fs/internal/state"
+)
+
+func TestTimeoutKillsTaskProcesses(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	workflow := fsparse.Workflow{
+		Name: "training",
+		Tasks: []fsparse.Task{
+			// The shell waits on a child that would outlive it if only the
+			// shell were killed
+			{ID: "train", Command: "sleep 30 & echo $! > " + pidFile + "; wait", Timeout: "300ms"},
+		},
+	}
+	workflowState := &state.WorkflowState{
+		WorkflowID: "training",
+		Tasks:      []state.TaskState{{ID: "train", Status: "pending"}},
+	}
+
+	NewOrchestrator(workflow, workflowState).Execute(context.Background())
+	if status := workflowState.Tasks[0].Status; status != "timeout" {
+		t.Fatalf("expected the task to time out, got %s", status)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for processRunning(pid) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("expected the task's child process %d to be killed", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// processRunning reports whether the process exists and isn't a zombie
+// waiting to be reaped
+func processRunning(pid int) bool {
+	if err := syscall.Kill(pid, 0); errors.Is(err, syscall.ESRCH) {
+		return false
+	}
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		// No /proc to tell a zombie apart, or the process just went away
+		return true
+	}
+	// The state follows the command name, which is in parentheses
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
@@ -0,0 +1,259 @@
+// Package planfile reads and writes saved plans. A saved plan holds every
+// resolved task spec and dependency edge that "tgfs apply" will execute,
+// together with a fingerprint of the workspace and state it was made from so
+// that a stale plan is never applied.
+package planfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zackiles/task-graph-fs/internal/config"
+	"github.com/zackiles/task-graph-fs/internal/fsparse"
+	"github.com/zackiles/task-graph-fs/internal/fsutil"
+	"github.com/zackiles/task-graph-fs/internal/state"
+)
+
+// Version is the version of the plan file format
+const Version = 1
+
+// DefaultFileName is the plan "tgfs plan" writes in the workspace root unless
+// told otherwise
+const DefaultFileName = ".tgfs-plan"
+
+// Plan is a saved plan
+type Plan struct {
+	Version     int       `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	WorkflowDir string    `json:"workflow_dir"`
+	// StatePath is the state file the plan was made against; empty means
+	// tgfs-state.json in WorkflowDir
+	StatePath string `json:"state_path,omitempty"`
+	// Fingerprint covers the workspace's task files, dependency links,
+	// configuration and the state the plan was computed against
+	Fingerprint string      `json:"fingerprint"`
+	Added       []string    `json:"added"`
+	Updated     []string    `json:"updated"`
+	Removed     []string    `json:"removed"`
+	HasChanges  bool        `json:"has_changes"`
+	Diff        *state.Diff `json:"diff,omitempty"`
+	Warnings    []string    `json:"warnings,omitempty"`
+	Workflows   []Workflow  `json:"workflows"`
+}
+
+// Workflow is a workflow as resolved at plan time
+type Workflow struct {
+	Name         string              `json:"name"`
+	Path         string              `json:"path"`
+	Tasks        []Task              `json:"tasks"`
+	Dependencies map[string][]string `json:"dependencies,omitempty"`
+}
+
+// Task is a fully resolved task spec
+type Task struct {
+	ID           string            `json:"id"`
+	MarkdownPath string            `json:"markdown_path"`
+	Command      string            `json:"command"`
+	Dependencies []string          `json:"dependencies"`
+	Priority     string            `json:"priority"`
+	Retries      int               `json:"retries"`
+	Timeout      string            `json:"timeout"`
+	Env          map[string]string `json:"env,omitempty"`
+	Workdir      string            `json:"workdir,omitempty"`
+	Hash         string            `json:"hash"`
+}
+
+// FromWorkflows converts parsed workflows into their saved form
+func FromWorkflows(workflows []fsparse.Workflow) []Workflow {
+	saved := make([]Workflow, len(workflows))
+	for i, w := range workflows {
+		saved[i] = Workflow{
+			Name:         w.Name,
+			Path:         w.Path,
+			Tasks:        make([]Task, len(w.Tasks)),
+			Dependencies: w.Dependencies,
+		}
+		for j, t := range w.Tasks {
+			deps := t.Dependencies
+			if deps == nil {
+				deps = []string{}
+			}
+			saved[i].Tasks[j] = Task{
+				ID:           t.ID,
+				MarkdownPath: t.MarkdownPath,
+				Command:      t.Command,
+				Dependencies: deps,
+				Priority:     t.Priority,
+				Retries:      t.Retries,
+				Timeout:      t.Timeout,
+				Env:          t.Env,
+				Workdir:      t.Workdir,
+				Hash:         w.TaskHash(t.ID),
+			}
+		}
+	}
+	return saved
+}
+
+// FsWorkflows converts the saved workflows back into the form the
+// orchestrator runs
+func (p *Plan) FsWorkflows() []fsparse.Workflow {
+	workflows := make([]fsparse.Workflow, len(p.Workflows))
+	for i, w := range p.Workflows {
+		deps := w.Dependencies
+		if deps == nil {
+			deps = make(map[string][]string)
+		}
+		workflows[i] = fsparse.Workflow{
+			Name:         w.Name,
+			Path:         w.Path,
+			Tasks:        make([]fsparse.Task, len(w.Tasks)),
+			Dependencies: deps,
+		}
+		for j, t := range w.Tasks {
+			workflows[i].Tasks[j] = fsparse.Task{
+				ID:           t.ID,
+				MarkdownPath: t.MarkdownPath,
+				Command:      t.Command,
+				Dependencies: t.Dependencies,
+				Priority:     t.Priority,
+				Retries:      t.Retries,
+				Timeout:      t.Timeout,
+				Env:          t.Env,
+				Workdir:      t.Workdir,
+				Status:       "pending",
+			}
+		}
+	}
+	return workflows
+}
+
+// StateFile returns the state file the plan was made against
+func (p *Plan) StateFile() string {
+	if p.StatePath != "" {
+		return p.StatePath
+	}
+	return filepath.Join(p.WorkflowDir, state.DefaultFileName)
+}
+
+// CheckWorkspace checks that the workspace the plan was made from still
+// exists, so that applying the plan doesn't create an empty one in its place
+func (p *Plan) CheckWorkspace() error {
+	info, err := os.Stat(p.WorkflowDir)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("the plan's workspace %s doesn't exist, make a new plan with `tgfs plan`", p.WorkflowDir)
+	}
+	return nil
+}
+
+// Write atomically saves the plan to the given path
+func (p *Plan) Write(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write plan file: %w", err)
+	}
+	return nil
+}
+
+// Read loads a saved plan
+func Read(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan file: %w", err)
+	}
+
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse plan file %s: %w", path, err)
+	}
+	if p.Version != Version {
+		return nil, fmt.Errorf("unsupported plan file version %d in %s, expected %d", p.Version, path, Version)
+	}
+	return &p, nil
+}
+
+// Fingerprint hashes everything a plan depends on: the path and content of
+// every task file, the target of every dependency symlink, the workspace
+// configuration and the current state. Hidden directories such as .tgfs are
+// ignored.
+func Fingerprint(workflowDir string, current *state.StateFile) (string, error) {
+	h := sha256.New()
+
+	var paths []string
+	err := filepath.Walk(workflowDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != workflowDir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint workspace: %w", err)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to fingerprint workspace: %w", err)
+		}
+		rel, err := filepath.Rel(workflowDir, path)
+		if err != nil {
+			return "", fmt.Errorf("failed to fingerprint workspace: %w", err)
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return "", fmt.Errorf("failed to fingerprint workspace: %w", err)
+			}
+			fmt.Fprintf(h, "link %s -> %s\n", rel, target)
+		case info.Mode().IsRegular() && (strings.HasSuffix(rel, ".md") || info.Name() == config.FileName):
+			fmt.Fprintf(h, "file %s\n", rel)
+			if err := hashFile(h, path); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	stateJSON, err := json.Marshal(current)
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint state: %w", err)
+	}
+	fmt.Fprintf(h, "state\n")
+	h.Write(stateJSON)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to fingerprint workspace: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to fingerprint workspace: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package planfile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zackiles/task-graph-fs/internal/fsparse"
+	"github.com/zackiles/task-graph-fs/internal/state"
+)
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	workflowDir := filepath.Join(dir, "workflow1")
+	if err := os.MkdirAll(workflowDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workflowDir, "taskA.md"), []byte("# TaskA\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint := func(st *state.StateFile) string {
+		t.Helper()
+		f, err := Fingerprint(dir, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	base := fingerprint(&state.StateFile{})
+
+	// Generated files under hidden directories don't count
+	if err := os.MkdirAll(filepath.Join(dir, ".tgfs", "runs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".tgfs", "runs", "log.md"), []byte("output"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if fingerprint(&state.StateFile{}) != base {
+		t.Error("expected hidden directories to be ignored")
+	}
+
+	if fingerprint(&state.StateFile{RunID: "run-1"}) == base {
+		t.Error("expected a state change to change the fingerprint")
+	}
+
+	if err := os.Symlink("taskA.md", filepath.Join(workflowDir, "taskB_dependencies")); err != nil {
+		t.Fatal(err)
+	}
+	linked := fingerprint(&state.StateFile{})
+	if linked == base {
+		t.Error("expected a new dependency link to change the fingerprint")
+	}
+
+	if err := os.WriteFile(filepath.Join(workflowDir, "taskA.md"), []byte("# TaskA edited\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if fingerprint(&state.StateFile{}) == linked {
+		t.Error("expected a task edit to change the fingerprint")
+	}
+}
+
+func TestPlanRoundTrip(t *testing.T) {
+	workflow := fsparse.Workflow{
+		Name: "workflow1",
+		Tasks: []fsparse.Task{
+			{ID: "taskA", Command: "echo a", Priority: "high", Timeout: "1m"},
+			{ID: "taskB", Command: "echo b", Retries: 2, Env: map[string]string{"MODE": "full"}, Workdir: "src"},
+		},
+		Dependencies: map[string][]string{"taskB": {"taskA"}},
+	}
+
+	path := filepath.Join(t.TempDir(), "plan.tgfs")
+	plan := &Plan{Version: Version, Workflows: FromWorkflows([]fsparse.Workflow{workflow}), HasChanges: true}
+	if err := plan.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// taskA has no dependencies, which is saved as an empty list
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(`"dependencies": null`)) {
+		t.Errorf("expected tasks without dependencies to be saved as [], got %s", data)
+	}
+
+	loaded, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := loaded.FsWorkflows()[0]
+	for _, task := range workflow.Tasks {
+		if restored.TaskHash(task.ID) != workflow.TaskHash(task.ID) {
+			t.Errorf("expected %s to round-trip unchanged", task.ID)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package printutils
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/zackiles/task-graph-fs/internal/state"
+)
+
+// PrintApplyResults prints how every workflow and task of an apply ended,
+// with the duration, attempts and exit code of each task that ran
+func PrintApplyResults(st *state.StateFile) {
+	fmt.Printf("\nApply Results:\n")
+	WriteResults(os.Stdout, st.Workflows, st.RunID)
+}
+
+// WriteResults writes the final status of every workflow and task of the run
+// runID to w. Tasks that ran in an earlier run are marked as such.
+func WriteResults(w io.Writer, workflows []state.WorkflowState, runID string) {
+	for _, wf := range workflows {
+		fmt.Fprintf(w, "  %s: %s", wf.WorkflowID, wf.Status)
+		if wf.Duration != "" {
+			fmt.Fprintf(w, " in %s", wf.Duration)
+		}
+		fmt.Fprintf(w, "\n")
+
+		for _, task := range wf.Tasks {
+			writeTaskResult(w, task, runID)
+		}
+	}
+}
+
+func writeTaskResult(w io.Writer, task state.TaskState, runID string) {
+	fmt.Fprintf(w, "    %s: %s", task.ID, task.Status)
+	switch {
+	case task.RunID != "" && task.RunID != runID:
+		fmt.Fprintf(w, " in an earlier run (%s)", task.RunID)
+	case task.Duration != "":
+		fmt.Fprintf(w, " in %s", task.Duration)
+		attempts := "attempts"
+		if task.AttemptCount == 1 {
+			attempts = "attempt"
+		}
+		fmt.Fprintf(w, " (%d %s", task.AttemptCount, attempts)
+		if task.ExitCode != nil {
+			fmt.Fprintf(w, ", exit code %d", *task.ExitCode)
+		}
+		fmt.Fprintf(w, ")")
+	}
+	if task.Error != "" {
+		fmt.Fprintf(w, ": %s", task.Error)
+	}
+	fmt.Fprintf(w, "\n")
+}
@@ -2,101 +2,71 @@ package printutils
 
 import (
 	"fmt"
-	"strings"
 
-	"github.com/zackiles/task-graph-fs/internal/fsparse"
 	"github.com/zackiles/task-graph-fs/internal/state"
 )
 
-func PrintTaskChanges(workflow fsparse.Workflow, currentTasks []state.TaskState) {
-	currentTaskMap := make(map[string]state.TaskState)
-	for _, t := range currentTasks {
-		currentTaskMap[t.ID] = t
-	}
-
+// PrintTaskChanges prints every task that is added, updated, removed or run
+// again. Unchanged tasks are left out.
+func PrintTaskChanges(tasks []state.TaskDiff) {
 	printed := false
-	for _, task := range workflow.Tasks {
-		if current, exists := currentTaskMap[task.ID]; exists {
-			if TaskHasChanges(task, current) {
-				if printed {
-					fmt.Printf(",\n")
-				}
-				PrintTaskUpdate(task, current, workflow.Dependencies[task.ID])
-				printed = true
-			}
-		} else {
-			if printed {
-				fmt.Printf(",\n")
-			}
-			PrintTaskAddition(task, workflow.Dependencies[task.ID])
-			printed = true
+	for _, task := range tasks {
+		if task.Change == state.ChangeNone && !task.Rerun {
+			continue
+		}
+		if printed {
+			fmt.Printf(",\n")
 		}
+		switch task.Change {
+		case state.ChangeAdd:
+			PrintTaskAddition(task)
+		case state.ChangeRemove:
+			PrintTaskRemoval(task)
+		default:
+			PrintTaskUpdate(task)
+		}
+		printed = true
 	}
 	fmt.Printf("\n")
 }
 
-func PrintTaskUpdate(new fsparse.Task, current state.TaskState, deps []string) {
-	fmt.Printf("        ~ \"tasks[%s]\" {\n", new.ID)
-	fmt.Printf("            \"id\": \"%s\",\n", new.ID)
-	if new.Command != current.Command {
-		fmt.Printf("            \"command\": \"%s\" -> \"%s\",\n", current.Command, new.Command)
-	}
-	if !StringSlicesEqual(deps, current.Dependencies) {
-		fmt.Printf("            \"dependencies\": [%s] -> [%s],\n",
-			FormatDependencies(current.Dependencies),
-			FormatDependencies(deps))
-	}
-	if new.Priority != current.Priority {
-		fmt.Printf("            \"priority\": \"%s\" -> \"%s\",\n", current.Priority, new.Priority)
-	}
-	if new.Retries != current.Retries {
-		fmt.Printf("            \"retries\": %d -> %d,\n", current.Retries, new.Retries)
+// PrintTaskUpdate prints the fields of a task that changed, as
+// "old" -> "new", followed by its status. A task that is only run again
+// shows its status moving back to pending.
+func PrintTaskUpdate(task state.TaskDiff) {
+	fmt.Printf("        ~ \"tasks[%s]\" {\n", task.ID)
+	fmt.Printf("            \"id\": \"%s\",\n", task.ID)
+	for _, field := range task.Fields {
+		fmt.Printf("            \"%s\": %s -> %s,\n", field.Field, field.Old, field.New)
 	}
-	if new.Timeout != "" {
-		fmt.Printf("            \"timeout\": \"%s\",\n", new.Timeout)
+	if task.Status != "pending" {
+		fmt.Printf("            \"status\": \"%s\" -> \"pending\"\n", task.Status)
+	} else {
+		fmt.Printf("            \"status\": \"pending\"\n")
 	}
-	fmt.Printf("            \"status\": \"%s\"\n", current.Status)
 	fmt.Printf("          }")
 }
 
-func PrintTaskAddition(task fsparse.Task, deps []string) {
+func PrintTaskAddition(task state.TaskDiff) {
 	fmt.Printf("        + \"tasks[%s]\" {\n", task.ID)
-	fmt.Printf("            \"id\": \"%s\",\n", task.ID)
-	fmt.Printf("            \"command\": \"%s\",\n", task.Command)
-	fmt.Printf("            \"dependencies\": [%s],\n", FormatDependencies(deps))
-	fmt.Printf("            \"priority\": \"%s\",\n", task.Priority)
-	fmt.Printf("            \"retries\": %d,\n", task.Retries)
-	fmt.Printf("            \"timeout\": \"%s\",\n", task.Timeout)
+	printTaskFields(task, "            ")
 	fmt.Printf("            \"status\": \"pending\"\n")
 	fmt.Printf("          }")
 }
 
-func FormatDependencies(deps []string) string {
-	if len(deps) == 0 {
-		return ""
-	}
-	quoted := make([]string, len(deps))
-	for i, dep := range deps {
-		quoted[i] = fmt.Sprintf("\"%s\"", dep)
-	}
-	return strings.Join(quoted, ", ")
-}
-
-func TaskHasChanges(newTask fsparse.Task, currentTask state.TaskState) bool {
-	return newTask.Command != currentTask.Command ||
-		!StringSlicesEqual(newTask.Dependencies, currentTask.Dependencies) ||
-		newTask.Priority != currentTask.Priority ||
-		newTask.Retries != currentTask.Retries
+func PrintTaskRemoval(task state.TaskDiff) {
+	fmt.Printf("        - \"tasks[%s]\" {\n", task.ID)
+	fmt.Printf("            \"id\": \"%s\",\n", task.ID)
+	fmt.Printf("            \"status\": \"%s\"\n", task.Status)
+	fmt.Printf("          }")
 }
 
-func StringSlicesEqual(a, b []string) bool {
-	if len(a) != len(b) {
-		return false
-	}
-	for i := range a {
-		if a[i] != b[i] {
-			return false
-		}
-	}
-	return true
+// printTaskFields prints a task's id and properties at the given indent
+func printTaskFields(task state.TaskDiff, indent string) {
+	fmt.Printf("%s\"id\": \"%s\",\n", indent, task.ID)
+	fmt.Printf("%s\"command\": %q,\n", indent, task.Command)
+	fmt.Printf("%s\"dependencies\": [%s],\n", indent, state.FormatDependencies(task.Dependencies))
+	fmt.Printf("%s\"priority\": \"%s\",\n", indent, task.Priority)
+	fmt.Printf("%s\"retries\": %d,\n", indent, task.Retries)
+	fmt.Printf("%s\"timeout\": \"%s\",\n", indent, task.Timeout)
 }
@@ -3,11 +3,47 @@ package printutils
 import (
 	"fmt"
 
-	"github.com/zackiles/task-graph-fs/internal/fsparse"
 	"github.com/zackiles/task-graph-fs/internal/state"
 )
 
-func PrintWorkflowAddition(w fsparse.Workflow) {
+// PrintPlan renders every change in the diff in the + ~ - format
+func PrintPlan(diff *state.Diff) {
+	fmt.Printf("TaskGraphFS Plan:\n\n")
+	if !diff.HasChanges() {
+		return
+	}
+
+	fmt.Printf("Workflow actions are indicated with the following symbols:\n\n")
+	fmt.Printf("  + add (new workflow/task)\n")
+	fmt.Printf("  ~ update (modified workflow/task)\n")
+	fmt.Printf("  - remove (deleted workflow/task)\n\n")
+
+	fmt.Printf("The following statefile changes will be made:\n\n")
+	for _, w := range diff.Workflows {
+		switch w.Change {
+		case state.ChangeAdd:
+			PrintWorkflowAddition(w)
+		case state.ChangeUpdate:
+			PrintWorkflowUpdate(w)
+		case state.ChangeRemove:
+			PrintWorkflowRemoval(w)
+		}
+	}
+}
+
+// PrintPlanSummary prints how many workflows and tasks are added, updated and
+// removed
+func PrintPlanSummary(diff *state.Diff) {
+	addedWorkflows, addedTasks := diff.Count(state.ChangeAdd)
+	updatedWorkflows, updatedTasks := diff.Count(state.ChangeUpdate)
+	removedWorkflows, removedTasks := diff.Count(state.ChangeRemove)
+
+	fmt.Println("Plan Summary:")
+	fmt.Printf("- Workflows: %d to add, %d to update, %d to remove.\n", addedWorkflows, updatedWorkflows, removedWorkflows)
+	fmt.Printf("- Tasks: %d to add, %d to update, %d to remove.\n", addedTasks, updatedTasks, removedTasks)
+}
+
+func PrintWorkflowAddition(w state.WorkflowDiff) {
 	fmt.Printf("  + \"workflows[%s]\" {\n", w.Name)
 	fmt.Printf("      \"workflow_id\": \"%s\",\n", w.Name)
 	fmt.Printf("      \"status\": \"pending\",\n")
@@ -15,12 +51,7 @@ func PrintWorkflowAddition(w fsparse.Workflow) {
 
 	for i, task := range w.Tasks {
 		fmt.Printf("        {\n")
-		fmt.Printf("          \"id\": \"%s\",\n", task.ID)
-		fmt.Printf("          \"command\": \"%s\",\n", task.Command)
-		fmt.Printf("          \"dependencies\": [%s],\n", FormatDependencies(w.Dependencies[task.ID]))
-		fmt.Printf("          \"priority\": \"%s\",\n", task.Priority)
-		fmt.Printf("          \"retries\": %d,\n", task.Retries)
-		fmt.Printf("          \"timeout\": \"%s\",\n", task.Timeout)
+		printTaskFields(task, "          ")
 		fmt.Printf("          \"status\": \"pending\"\n")
 		fmt.Printf("        }")
 		if i < len(w.Tasks)-1 {
@@ -33,25 +64,19 @@ func PrintWorkflowAddition(w fsparse.Workflow) {
 	fmt.Printf("    }\n\n")
 }
 
-func PrintWorkflowUpdate(new fsparse.Workflow, current *state.WorkflowState) {
-	if current == nil {
-		return
-	}
-	fmt.Printf("  ~ \"workflows[%s]\" {\n", new.Name)
-	fmt.Printf("      \"workflow_id\": \"%s\",\n", new.Name)
+func PrintWorkflowUpdate(w state.WorkflowDiff) {
+	fmt.Printf("  ~ \"workflows[%s]\" {\n", w.Name)
+	fmt.Printf("      \"workflow_id\": \"%s\",\n", w.Name)
 
 	fmt.Printf("      \"tasks\": [\n")
-	PrintTaskChanges(new, current.Tasks)
+	PrintTaskChanges(w.Tasks)
 	fmt.Printf("      ]\n")
 	fmt.Printf("    }\n\n")
 }
 
-func PrintWorkflowRemoval(w *state.WorkflowState) {
-	if w == nil {
-		return
-	}
-	fmt.Printf("  - \"workflows[%s]\" {\n", w.WorkflowID)
-	fmt.Printf("      \"workflow_id\": \"%s\",\n", w.WorkflowID)
+func PrintWorkflowRemoval(w state.WorkflowDiff) {
+	fmt.Printf("  - \"workflows[%s]\" {\n", w.Name)
+	fmt.Printf("      \"workflow_id\": \"%s\",\n", w.Name)
 	fmt.Printf("      \"status\": \"%s\",\n", w.Status)
 	fmt.Printf("      \"tasks\": []\n")
 	fmt.Printf("    }\n\n")
@@ -0,0 +1,212 @@
+// Package report renders plans and apply progress in the machine-readable
+// formats selected with --output json.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/zackiles/task-graph-fs/internal/orchestration"
+	"github.com/zackiles/task-graph-fs/internal/state"
+)
+
+// SchemaVersion is the version of the JSON documents and events written by
+// this package. It changes whenever a field is removed or changes meaning;
+// new fields may be added without a version change.
+const SchemaVersion = 1
+
+// Output formats accepted by --output
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Events written by an EventWriter in addition to the orchestration events
+const (
+	// EventPlan carries the plan an apply is about to execute
+	EventPlan = "plan"
+	// EventApplyFinished is always the last event of an apply
+	EventApplyFinished = "apply_finished"
+)
+
+// Statuses of an apply_finished event
+const (
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+	StatusUnchanged = "unchanged"
+)
+
+// CheckFormat returns an error unless format is a supported output format
+func CheckFormat(format string) error {
+	switch format {
+	case FormatText, FormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format %q, expected %q or %q", format, FormatText, FormatJSON)
+	}
+}
+
+// Counts is the number of additions, updates and removals of one kind
+type Counts struct {
+	Add    int `json:"add"`
+	Update int `json:"update"`
+	Remove int `json:"remove"`
+}
+
+// Summary counts the changes of a plan for workflows and tasks
+type Summary struct {
+	Workflows Counts `json:"workflows"`
+	Tasks     Counts `json:"tasks"`
+}
+
+// Plan is the document written by `tgfs plan --output json`
+type Plan struct {
+	SchemaVersion int  `json:"schema_version"`
+	HasChanges    bool `json:"has_changes"`
+	// PlanFile is where the plan was saved, empty when it wasn't
+	PlanFile string      `json:"plan_file,omitempty"`
+	Summary  Summary     `json:"summary"`
+	Warnings []string    `json:"warnings"`
+	Diff     *state.Diff `json:"diff"`
+}
+
+// NewPlan builds the plan document for a diff
+func NewPlan(diff *state.Diff, warnings []string, planFile string) Plan {
+	if diff == nil {
+		diff = &state.Diff{}
+	}
+	if diff.Workflows == nil {
+		diff.Workflows = []state.WorkflowDiff{}
+	}
+	if warnings == nil {
+		warnings = []string{}
+	}
+	return Plan{
+		SchemaVersion: SchemaVersion,
+		HasChanges:    diff.HasChanges(),
+		PlanFile:      planFile,
+		Summary:       summarize(diff),
+		Warnings:      warnings,
+		Diff:          diff,
+	}
+}
+
+func summarize(diff *state.Diff) Summary {
+	var s Summary
+	s.Workflows.Add, s.Tasks.Add = diff.Count(state.ChangeAdd)
+	s.Workflows.Update, s.Tasks.Update = diff.Count(state.ChangeUpdate)
+	s.Workflows.Remove, s.Tasks.Remove = diff.Count(state.ChangeRemove)
+	return s
+}
+
+// WritePlan writes the plan document as indented JSON
+func WritePlan(w io.Writer, plan Plan) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(plan); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
+
+// Event is a single line of the NDJSON stream written by
+// `tgfs apply --output json`
+type Event struct {
+	SchemaVersion int       `json:"schema_version"`
+	Type          string    `json:"type"`
+	Time          time.Time `json:"time"`
+	RunID         string    `json:"run_id,omitempty"`
+	Workflow      string    `json:"workflow,omitempty"`
+	Task          string    `json:"task,omitempty"`
+	Status        string    `json:"status,omitempty"`
+	Attempt       int       `json:"attempt,omitempty"`
+	ExitCode      *int      `json:"exit_code,omitempty"`
+	DurationMS    *int64    `json:"duration_ms,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Plan          *Plan     `json:"plan,omitempty"`
+}
+
+// EventWriter writes apply events as newline-delimited JSON. It is safe for
+// concurrent use.
+type EventWriter struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	started time.Time
+	runID   string
+}
+
+// NewEventWriter creates an EventWriter that writes to w. The duration of the
+// apply_finished event is measured from this call.
+func NewEventWriter(w io.Writer) *EventWriter {
+	return &EventWriter{
+		enc:     json.NewEncoder(w),
+		started: time.Now(),
+	}
+}
+
+// Plan writes the plan the apply is about to execute
+func (w *EventWriter) Plan(plan Plan) {
+	w.write(Event{Type: EventPlan, Time: time.Now().UTC(), Plan: &plan})
+}
+
+// Orchestration writes an event reported by the orchestrator
+func (w *EventWriter) Orchestration(e orchestration.Event) {
+	event := Event{
+		Type:     string(e.Type),
+		Time:     e.Time,
+		RunID:    e.RunID,
+		Workflow: e.Workflow,
+		Task:     e.Task,
+		Status:   e.Status,
+		Attempt:  e.Attempt,
+		Error:    e.Error,
+	}
+	switch e.Type {
+	case orchestration.EventTaskRetrying:
+		event.ExitCode = &e.ExitCode
+	case orchestration.EventTaskFinished:
+		event.ExitCode = &e.ExitCode
+		event.DurationMS = durationMS(e.Duration)
+	case orchestration.EventWorkflowFinished:
+		event.DurationMS = durationMS(e.Duration)
+	}
+	w.write(event)
+}
+
+// Finished writes the apply_finished event with the apply's final status
+func (w *EventWriter) Finished(status string, err error) {
+	event := Event{
+		Type:       EventApplyFinished,
+		Time:       time.Now().UTC(),
+		Status:     status,
+		DurationMS: durationMS(time.Since(w.started)),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	w.write(event)
+}
+
+func (w *EventWriter) write(event Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Every event after apply_started belongs to its run
+	if event.RunID != "" {
+		w.runID = event.RunID
+	} else {
+		event.RunID = w.runID
+	}
+	event.SchemaVersion = SchemaVersion
+	// Progress is best-effort: a closed stdout must not fail the apply
+	_ = w.enc.Encode(event)
+}
+
+func durationMS(d time.Duration) *int64 {
+	ms := d.Milliseconds()
+	return &ms
+}
@@ -0,0 +1,101 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zackiles/task-graph-fs/internal/orchestration"
+	"github.com/zackiles/task-graph-fs/internal/state"
+)
+
+func TestEventWriter(t *testing.T) {
+	var out bytes.Buffer
+	events := NewEventWriter(&out)
+
+	events.Plan(NewPlan(nil, nil, ""))
+	events.Orchestration(orchestration.Event{Type: orchestration.EventApplyStarted, RunID: "run-1"})
+	events.Orchestration(orchestration.Event{
+		Type:     orchestration.EventTaskFinished,
+		RunID:    "run-1",
+		Workflow: "ci",
+		Task:     "build",
+		Status:   "completed",
+		Attempt:  1,
+		Duration: 1500 * time.Millisecond,
+	})
+	events.Orchestration(orchestration.Event{
+		Type:     orchestration.EventWorkflowFinished,
+		RunID:    "run-1",
+		Workflow: "ci",
+		Status:   "completed",
+	})
+	events.Finished(StatusFailed, errors.New("boom"))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 events, got %d:\n%s", len(lines), out.String())
+	}
+
+	decoded := make([]Event, len(lines))
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &decoded[i]); err != nil {
+			t.Fatal(err)
+		}
+		if decoded[i].SchemaVersion != SchemaVersion {
+			t.Errorf("event %d: expected schema version %d, got %d", i, SchemaVersion, decoded[i].SchemaVersion)
+		}
+	}
+
+	if decoded[0].Plan == nil || decoded[0].Plan.Diff == nil || decoded[0].RunID != "" {
+		t.Errorf("expected the plan event to carry an empty diff and no run, got %+v", decoded[0])
+	}
+	task := decoded[2]
+	if task.ExitCode == nil || *task.ExitCode != 0 || task.DurationMS == nil || *task.DurationMS != 1500 {
+		t.Errorf("expected task exit code 0 and duration 1500ms, got %+v", task)
+	}
+	if decoded[3].ExitCode != nil {
+		t.Errorf("expected no exit code on a workflow event, got %+v", decoded[3])
+	}
+	finished := decoded[4]
+	if finished.Type != EventApplyFinished || finished.Status != StatusFailed || finished.Error != "boom" || finished.RunID != "run-1" {
+		t.Errorf("unexpected apply_finished event: %+v", finished)
+	}
+}
+
+func TestNewPlanSummary(t *testing.T) {
+	diff := &state.Diff{Workflows: []state.WorkflowDiff{
+		{Name: "ci", Change: state.ChangeUpdate, Tasks: []state.TaskDiff{
+			{ID: "build", Change: state.ChangeAdd},
+			{ID: "lint", Change: state.ChangeRemove},
+			{ID: "test", Change: state.ChangeNone},
+		}},
+		{Name: "old", Change: state.ChangeRemove},
+	}}
+
+	plan := NewPlan(diff, nil, "plan.tgfs")
+	want := Summary{
+		Workflows: Counts{Update: 1, Remove: 1},
+		Tasks:     Counts{Add: 1, Remove: 1},
+	}
+	if plan.Summary != want {
+		t.Errorf("expected summary %+v, got %+v", want, plan.Summary)
+	}
+	if !plan.HasChanges || plan.PlanFile != "plan.tgfs" || plan.Warnings == nil {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	for _, format := range []string{FormatText, FormatJSON} {
+		if err := CheckFormat(format); err != nil {
+			t.Errorf("expected %q to be accepted, got %v", format, err)
+		}
+	}
+	if err := CheckFormat("yaml"); err == nil {
+		t.Error("expected an unsupported format to be rejected")
+	}
+}
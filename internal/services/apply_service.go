@@ -2,13 +2,19 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/zackiles/task-graph-fs/internal/fsparse"
+	"github.com/zackiles/task-graph-fs/internal/history"
 	"github.com/zackiles/task-graph-fs/internal/orchestration"
+	"github.com/zackiles/task-graph-fs/internal/planfile"
 	"github.com/zackiles/task-graph-fs/internal/state"
+	"github.com/zackiles/task-graph-fs/internal/workspace"
 )
 
 type ApplyService struct {
@@ -23,103 +29,444 @@ func NewApplyService(parser *fsparse.Parser) *ApplyService {
 
 type ApplyOptions struct {
 	WorkflowDir string
+	// StatePath is the state file to read and write; empty uses
+	// tgfs-state.json in WorkflowDir
+	StatePath   string
 	AutoApprove bool
+	// RetryPolicy controls the backoff between task retries; the zero value
+	// uses orchestration.DefaultRetryPolicy
+	RetryPolicy orchestration.RetryPolicy
+	// Retention limits the run records kept after the apply; the zero value
+	// keeps every run
+	Retention history.Retention
+	// Timeout bounds the execution of the whole apply; zero means no limit
+	Timeout time.Duration
+	// MaxRunning caps how many tasks run at once across all workflows; zero
+	// means no limit
+	MaxRunning int
+	// Workflows holds per-workflow settings, keyed by workflow name
+	Workflows map[string]WorkflowOptions
+	// OnEvent, when set, receives the progress of the apply as it happens. It
+	// must be safe for concurrent use.
+	OnEvent func(orchestration.Event)
+}
+
+// WorkflowOptions configures how a single workflow runs
+type WorkflowOptions struct {
+	// Timeout bounds the workflow's run; zero means no limit
+	Timeout time.Duration
+	// MaxRunning caps how many of the workflow's tasks run at once; zero
+	// means no limit
+	MaxRunning int
 }
 
 type ApplyResult struct {
+	// StatePath is the state file the plan was made against
+	StatePath  string
 	Added      []string
 	Updated    []string
 	Removed    []string
 	HasChanges bool
+	// Diff is the task-level change behind Added, Updated and Removed
+	Diff *state.Diff
+	// Warnings are non-fatal problems found while parsing task files, each
+	// prefixed with the qualified ID of its task
+	Warnings []string
+	// Workflows are the parsed workflows the plan would execute
+	Workflows []fsparse.Workflow
+	// Fingerprint identifies the workspace and state the plan was made from
+	Fingerprint string
+}
+
+// statePath returns the state file the options refer to
+func (o ApplyOptions) statePath() string {
+	if o.StatePath != "" {
+		return o.StatePath
+	}
+	return filepath.Join(o.WorkflowDir, state.DefaultFileName)
 }
 
+// ErrStalePlan is returned when applying a saved plan whose workspace or state
+// has changed since the plan was made
+var ErrStalePlan = errors.New("the workspace or state changed since the plan was made, run `tgfs plan` again")
+
 func (s *ApplyService) Plan(ctx context.Context, opts ApplyOptions) (*ApplyResult, error) {
-	workflows, err := s.parser.ParseWorkflows(ctx, opts.WorkflowDir)
+	currentState, err := state.LoadStateFrom(ctx, opts.statePath())
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse workflows: %w", err)
+		return nil, fmt.Errorf("failed to load state: %w", err)
 	}
 
-	currentState, err := state.LoadState(ctx)
+	// Fingerprint the sources before parsing them, so that a change made while
+	// parsing makes the plan stale rather than going unnoticed
+	fingerprint, err := planfile.Fingerprint(opts.WorkflowDir, currentState)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load state: %w", err)
+		return nil, err
+	}
+
+	workflows, err := s.parser.Validate(ctx, opts.WorkflowDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate workflows: %w", err)
 	}
 
-	added, updated, removed, err := currentState.ComputeDiff(ctx, workflows)
+	diff, err := currentState.Diff(ctx, workflows)
 	if err != nil {
 		return nil, fmt.Errorf("failed to compute diff: %w", err)
 	}
 
 	return &ApplyResult{
-		Added:      added,
-		Updated:    updated,
-		Removed:    removed,
-		HasChanges: len(added)+len(updated)+len(removed) > 0,
+		StatePath:   opts.statePath(),
+		Added:       diff.WorkflowNames(state.ChangeAdd),
+		Updated:     diff.WorkflowNames(state.ChangeUpdate),
+		Removed:     diff.WorkflowNames(state.ChangeRemove),
+		HasChanges:  diff.HasChanges(),
+		Diff:        diff,
+		Warnings:    taskWarnings(workflows),
+		Workflows:   workflows,
+		Fingerprint: fingerprint,
+	}, nil
+}
+
+// SavedPlan returns the plan in the form written to a plan file. Its paths
+// are made absolute so that the plan can be applied from any directory.
+func (r *ApplyResult) SavedPlan(workflowDir string) (*planfile.Plan, error) {
+	workflowDir, err := filepath.Abs(workflowDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve workspace path: %w", err)
+	}
+	statePath, err := filepath.Abs(r.StatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve state path: %w", err)
+	}
+
+	workflows := planfile.FromWorkflows(r.Workflows)
+	for i := range workflows {
+		if workflows[i].Path, err = filepath.Abs(workflows[i].Path); err != nil {
+			return nil, fmt.Errorf("failed to resolve workflow path: %w", err)
+		}
+		for j := range workflows[i].Tasks {
+			task := &workflows[i].Tasks[j]
+			if task.MarkdownPath, err = filepath.Abs(task.MarkdownPath); err != nil {
+				return nil, fmt.Errorf("failed to resolve task path: %w", err)
+			}
+		}
+	}
+
+	return &planfile.Plan{
+		Version:     planfile.Version,
+		CreatedAt:   time.Now().UTC(),
+		WorkflowDir: workflowDir,
+		StatePath:   statePath,
+		Fingerprint: r.Fingerprint,
+		Added:       r.Added,
+		Updated:     r.Updated,
+		Removed:     r.Removed,
+		HasChanges:  r.HasChanges,
+		Diff:        r.Diff,
+		Warnings:    r.Warnings,
+		Workflows:   workflows,
 	}, nil
 }
 
-func (s *ApplyService) Apply(ctx context.Context, opts ApplyOptions) error {
-	// Create a new context with timeout for the entire apply operation
-	// Use a shorter timeout for tests
-	timeout := 30 * time.Second
-	if os.Getenv("TEST_ENV") == "true" {
-		timeout = 5 * time.Second
+// taskWarnings collects the parse warnings of every task
+func taskWarnings(workflows []fsparse.Workflow) []string {
+	var warnings []string
+	for _, w := range workflows {
+		for _, t := range w.Tasks {
+			for _, warning := range t.Warnings {
+				warnings = append(warnings, fmt.Sprintf("%s: %s", fsparse.QualifiedTaskID(w.Name, t.ID), warning))
+			}
+		}
+	}
+	return warnings
+}
+
+// Apply parses the workflows and executes them
+func (s *ApplyService) Apply(ctx context.Context, opts ApplyOptions) (*state.StateFile, error) {
+	workflows, err := s.parser.Validate(ctx, opts.WorkflowDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate workflows: %w", err)
 	}
+	return s.ApplyWorkflows(ctx, opts, workflows)
+}
 
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+// ApplyPlan executes exactly the workflows of a saved plan, against the
+// workspace and state file it was made from. It refuses with ErrStalePlan if
+// the plan's workspace or the state has changed since.
+func (s *ApplyService) ApplyPlan(ctx context.Context, opts ApplyOptions, plan *planfile.Plan) (*state.StateFile, error) {
+	if err := plan.CheckWorkspace(); err != nil {
+		return nil, err
+	}
+	opts.WorkflowDir = plan.WorkflowDir
+	opts.StatePath = plan.StateFile()
 
-	workflows, err := s.parser.ParseWorkflows(ctx, opts.WorkflowDir)
+	currentState, err := state.LoadStateFrom(ctx, opts.statePath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	fingerprint, err := planfile.Fingerprint(plan.WorkflowDir, currentState)
 	if err != nil {
-		return fmt.Errorf("failed to parse workflows: %w", err)
+		return nil, err
 	}
+	if fingerprint != plan.Fingerprint {
+		return nil, ErrStalePlan
+	}
+
+	return s.ApplyWorkflows(ctx, opts, plan.FsWorkflows())
+}
 
-	newState := &state.StateFile{}
+// ApplyWorkflows executes already parsed workflows, resuming past tasks that
+// completed in a previous apply, and saves the resulting state. The state is
+// returned whenever the workflows ran, including when some of them failed.
+func (s *ApplyService) ApplyWorkflows(ctx context.Context, opts ApplyOptions, workflows []fsparse.Workflow) (*state.StateFile, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, opts.Timeout, fmt.Errorf("apply timed out after %s: %w", opts.Timeout, context.DeadlineExceeded))
+		defer cancel()
+	}
+
+	orchestratorOpts := orchestration.DefaultOptions()
+	if opts.RetryPolicy != (orchestration.RetryPolicy{}) {
+		orchestratorOpts.RetryPolicy = opts.RetryPolicy
+	}
 
-	for _, workflow := range workflows {
-		// Check context before starting each workflow
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+	// Every apply gets its own run directory for task logs and its record
+	started := time.Now()
+	runID := workspace.NewRunID()
+	orchestratorOpts.RunID = runID
+	orchestratorOpts.LogDir = workspace.RunDir(opts.WorkflowDir, runID)
+	orchestratorOpts.OnEvent = opts.OnEvent
+
+	emit := func(e orchestration.Event) {
+		if opts.OnEvent != nil {
+			e.Time = time.Now().UTC()
+			e.RunID = runID
+			opts.OnEvent(e)
 		}
+	}
 
-		workflowState := state.WorkflowState{
+	// The previous state tells us which tasks already completed and can be
+	// resumed past rather than run again
+	previousState, err := state.LoadStateFrom(ctx, opts.statePath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	// Workflows whose tasks all completed in a previous apply and are
+	// unchanged are left alone and keep their previous state. Workflows that
+	// are no longer in the workspace are dropped.
+	newState := &state.StateFile{
+		RunID:     runID,
+		Workflows: make([]state.WorkflowState, len(workflows)),
+	}
+	touched := make([]bool, len(workflows))
+
+	// Workflows run concurrently; the coordinator holds back tasks whose
+	// upstream dependencies live in another workflow until those complete
+	coordinator := orchestration.NewCoordinator(workflows)
+	orchestratorOpts.Coordinator = coordinator
+	orchestratorOpts.Limiter = orchestration.NewLimiter(opts.MaxRunning)
+
+	toRun := previousState.TasksToRun(workflows)
+	for i, workflow := range workflows {
+		previousWorkflow := previousState.FindWorkflow(workflow.Name)
+		if untouched(workflow, previousWorkflow, toRun) {
+			newState.Workflows[i] = *previousWorkflow
+			for _, task := range workflow.Tasks {
+				coordinator.Finish(fsparse.QualifiedTaskID(workflow.Name, task.ID), true)
+			}
+			continue
+		}
+
+		touched[i] = true
+		newState.Workflows[i] = state.WorkflowState{
 			WorkflowID: workflow.Name,
 			Status:     "running",
 			Tasks:      make([]state.TaskState, len(workflow.Tasks)),
 		}
+		for j, task := range workflow.Tasks {
+			run := toRun[fsparse.QualifiedTaskID(workflow.Name, task.ID)]
+			newState.Workflows[i].Tasks[j] = resumeTaskState(workflow, task, previousWorkflow, run)
+		}
+	}
 
-		for i, task := range workflow.Tasks {
-			workflowState.Tasks[i] = state.TaskState{
-				ID:           task.ID,
-				Command:      task.Command,
-				Dependencies: task.Dependencies,
-				Priority:     task.Priority,
-				Retries:      task.Retries,
-				Status:       "pending",
-			}
+	// Task transitions are saved in the background as they happen, so that an
+	// interrupted or crashed apply leaves an accurate state to resume from. A
+	// failed intermediate save is not fatal: the final save below reports it.
+	saveCtx := context.WithoutCancel(ctx)
+	recorder := state.NewRecorder(newState, opts.statePath())
+	orchestratorOpts.StateLock = recorder
+	orchestratorOpts.OnStateChange = recorder.Changed
+	if err := recorder.Save(saveCtx); err != nil {
+		return nil, fmt.Errorf("failed to save state: %w", err)
+	}
+	recorder.Start()
+
+	emit(orchestration.Event{Type: orchestration.EventApplyStarted})
+
+	errs := make([]error, len(workflows))
+	var wg sync.WaitGroup
+	for i, workflow := range workflows {
+		if !touched[i] {
+			continue
 		}
+		wg.Add(1)
+		go func(i int, workflow fsparse.Workflow) {
+			defer wg.Done()
+
+			started := time.Now()
+			startedAt := started.UTC()
+			workflowState := &newState.Workflows[i]
+			recorder.Update(func(*state.StateFile) {
+				workflowState.StartedAt = &startedAt
+			})
 
-		orchestrator := orchestration.NewOrchestrator(workflow, &workflowState)
-		if err := orchestrator.Execute(ctx); err != nil {
-			if err == context.Canceled {
-				workflowState.Status = "cancelled"
-			} else {
-				workflowState.Status = "failed"
+			workflowCtx := ctx
+			if timeout := opts.Workflows[workflow.Name].Timeout; timeout > 0 {
+				var cancel context.CancelFunc
+				workflowCtx, cancel = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("workflow %s timed out after %s: %w", workflow.Name, timeout, context.DeadlineExceeded))
+				defer cancel()
+			}
+
+			workflowOpts := orchestratorOpts
+			workflowOpts.MaxRunning = opts.Workflows[workflow.Name].MaxRunning
+			orchestrator := orchestration.NewOrchestratorWithOptions(workflow, workflowState, workflowOpts)
+			err := orchestrator.Execute(workflowCtx)
+			if err == nil {
+				// Task failures don't abort Execute, they're collected on the orchestrator
+				err = orchestrator.Err()
+			}
+			if err != nil && errors.Is(workflowCtx.Err(), context.DeadlineExceeded) {
+				// Report which deadline, the workflow's or the apply's, ran out
+				err = context.Cause(workflowCtx)
 			}
-			// Save partial state before returning
-			_ = newState.Save(ctx)
-			return fmt.Errorf("workflow %s failed: %w", workflow.Name, err)
-		}
 
-		workflowState.Status = "completed"
-		newState.Workflows = append(newState.Workflows, workflowState)
+			duration := time.Since(started)
+			endedAt := time.Now().UTC()
+			recorder.Update(func(*state.StateFile) {
+				workflowState.EndedAt = &endedAt
+				workflowState.Duration = state.FormatDuration(duration)
+				switch {
+				case err == nil:
+					workflowState.Status = "completed"
+				case errors.Is(err, context.Canceled):
+					workflowState.Status = "cancelled"
+				case errors.Is(err, context.DeadlineExceeded):
+					workflowState.Status = "timeout"
+				default:
+					workflowState.Status = "failed"
+				}
+				if err != nil {
+					workflowState.Error = err.Error()
+				}
+			})
+			recorder.Changed()
+			if err != nil {
+				errs[i] = fmt.Errorf("workflow %s failed: %w", workflow.Name, err)
+			}
+
+			emit(orchestration.Event{
+				Type:     orchestration.EventWorkflowFinished,
+				Workflow: workflow.Name,
+				Status:   workflowState.Status,
+				Duration: duration,
+				Error:    workflowState.Error,
+			})
+		}(i, workflow)
 	}
+	wg.Wait()
 
-	// Final state save
-	if err := newState.Save(ctx); err != nil {
-		return fmt.Errorf("failed to save state: %w", err)
+	// Save the final state even if the apply failed or was cancelled so that
+	// the next apply can resume
+	if err := recorder.Close(saveCtx); err != nil {
+		return newState, fmt.Errorf("failed to save state: %w", err)
 	}
 
+	applyErr := errors.Join(errs...)
+	if err := s.recordRun(opts, newState, workflows, started, applyErr); err != nil {
+		return newState, errors.Join(applyErr, err)
+	}
+
+	return newState, applyErr
+}
+
+// recordRun writes the run's history record and prunes old runs beyond the
+// retention limits
+func (s *ApplyService) recordRun(opts ApplyOptions, st *state.StateFile, workflows []fsparse.Workflow, started time.Time, applyErr error) error {
+	status := "completed"
+	for _, w := range st.Workflows {
+		if w.Status == "cancelled" {
+			status = "cancelled"
+			break
+		}
+		if w.Status != "completed" {
+			status = "failed"
+		}
+	}
+
+	record := &history.Record{
+		RunID:       st.RunID,
+		WorkflowDir: opts.WorkflowDir,
+		StatePath:   opts.statePath(),
+		StartedAt:   started.UTC(),
+		EndedAt:     time.Now().UTC(),
+		Duration:    state.FormatDuration(time.Since(started)),
+		Status:      status,
+		Workflows:   planfile.FromWorkflows(workflows),
+		Results:     st.Workflows,
+	}
+	if applyErr != nil {
+		record.Error = applyErr.Error()
+	}
+	if err := history.Write(opts.WorkflowDir, record); err != nil {
+		return err
+	}
+
+	// Pruning is best effort and is retried after the next apply. Runs whose
+	// logs the state still points to are kept.
+	if _, err := history.Prune(opts.WorkflowDir, opts.Retention, time.Now(), history.ReferencedRuns(st)); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to prune run history: %v\n", err)
+	}
 	return nil
 }
+
+// untouched reports whether a workflow has nothing to do in this apply: it
+// completed in a previous apply, none of its tasks run and no task was
+// removed from it
+func untouched(workflow fsparse.Workflow, previous *state.WorkflowState, toRun map[string]bool) bool {
+	if previous == nil || previous.Status != "completed" || len(previous.Tasks) != len(workflow.Tasks) {
+		return false
+	}
+	for _, task := range workflow.Tasks {
+		if toRun[fsparse.QualifiedTaskID(workflow.Name, task.ID)] {
+			return false
+		}
+	}
+	return true
+}
+
+// resumeTaskState builds the initial state of a task for this apply. A task
+// the apply doesn't run (see state.TasksToRun) keeps the state of the apply
+// that completed it; every other task (pending, running, failed, timed out,
+// modified or downstream of one of those) is scheduled to run.
+func resumeTaskState(workflow fsparse.Workflow, task fsparse.Task, previous *state.WorkflowState, run bool) state.TaskState {
+	hash := workflow.TaskHash(task.ID)
+
+	if previous != nil && !run {
+		if prev := previous.FindTask(task.ID); prev != nil {
+			return *prev
+		}
+	}
+
+	return state.TaskState{
+		ID:           task.ID,
+		Command:      task.Command,
+		Dependencies: workflow.TaskDependencies(task.ID),
+		Priority:     task.Priority,
+		Retries:      task.Retries,
+		Timeout:      task.Timeout,
+		Env:          task.Env,
+		Workdir:      task.Workdir,
+		Status:       "pending",
+		Hash:         hash,
+	}
+}
@@ -0,0 +1,293 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zackiles/task-graph-fs/internal/fsparse"
+)
+
+// Change describes what a plan does to a workflow or task
+type Change string
+
+const (
+	ChangeNone   Change = "no-op"
+	ChangeAdd    Change = "add"
+	ChangeUpdate Change = "update"
+	ChangeRemove Change = "remove"
+)
+
+// FieldChange is a single task property whose value differs from the state.
+// Values are rendered as they appear in the plan output.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// TaskDiff is the planned change to a single task. The property values are
+// the task's new definition, or its last known one for a removed task.
+type TaskDiff struct {
+	ID           string        `json:"id"`
+	Change       Change        `json:"change"`
+	Command      string        `json:"command"`
+	Dependencies []string      `json:"dependencies"`
+	Priority     string        `json:"priority"`
+	Retries      int           `json:"retries"`
+	Timeout      string        `json:"timeout"`
+	Fields       []FieldChange `json:"fields,omitempty"`
+	// Status is the task's status in the state, or "pending" for a new task
+	Status string `json:"status"`
+	// Rerun is set for an unchanged task that will run again, because it
+	// hasn't completed yet or a task upstream of it runs
+	Rerun bool `json:"rerun,omitempty"`
+}
+
+// WorkflowDiff is the planned change to a workflow and its tasks
+type WorkflowDiff struct {
+	Name   string     `json:"name"`
+	Change Change     `json:"change"`
+	Status string     `json:"status"`
+	Tasks  []TaskDiff `json:"tasks"`
+}
+
+// Diff is the planned change to every workflow
+type Diff struct {
+	Workflows []WorkflowDiff `json:"workflows"`
+}
+
+// HasChanges reports whether applying the diff would do anything
+func (d *Diff) HasChanges() bool {
+	for _, w := range d.Workflows {
+		if w.Change != ChangeNone {
+			return true
+		}
+	}
+	return false
+}
+
+// WorkflowNames returns the names of the workflows with the given change
+func (d *Diff) WorkflowNames(change Change) []string {
+	var names []string
+	for _, w := range d.Workflows {
+		if w.Change == change {
+			names = append(names, w.Name)
+		}
+	}
+	return names
+}
+
+// Count returns how many workflows and tasks have the given change
+func (d *Diff) Count(change Change) (workflows, tasks int) {
+	for _, w := range d.Workflows {
+		if w.Change == change {
+			workflows++
+		}
+		for _, t := range w.Tasks {
+			if t.Change == change {
+				tasks++
+			}
+		}
+	}
+	return workflows, tasks
+}
+
+// Diff compares the state with the parsed workflows task by task. Workflows
+// and tasks are listed in the order of the workflows, followed by those only
+// found in the state.
+func (s *StateFile) Diff(ctx context.Context, workflows []fsparse.Workflow) (*Diff, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	diff := &Diff{}
+	seen := make(map[string]bool)
+	toRun := s.TasksToRun(workflows)
+
+	for _, w := range workflows {
+		seen[w.Name] = true
+		current := s.FindWorkflow(w.Name)
+
+		wd := WorkflowDiff{Name: w.Name, Change: ChangeNone, Status: "pending"}
+		if current == nil {
+			wd.Change = ChangeAdd
+		} else {
+			wd.Status = current.Status
+		}
+
+		taskSeen := make(map[string]bool)
+		for _, task := range w.Tasks {
+			taskSeen[task.ID] = true
+			var prev *TaskState
+			if current != nil {
+				prev = current.FindTask(task.ID)
+			}
+			td := diffTask(w, task, prev, toRun[fsparse.QualifiedTaskID(w.Name, task.ID)])
+			if wd.Change == ChangeNone && (td.Change != ChangeNone || td.Rerun) {
+				wd.Change = ChangeUpdate
+			}
+			wd.Tasks = append(wd.Tasks, td)
+		}
+
+		if current != nil {
+			for _, prev := range current.Tasks {
+				if taskSeen[prev.ID] {
+					continue
+				}
+				wd.Tasks = append(wd.Tasks, removedTask(prev))
+				if wd.Change == ChangeNone {
+					wd.Change = ChangeUpdate
+				}
+			}
+		}
+
+		diff.Workflows = append(diff.Workflows, wd)
+	}
+
+	for _, current := range s.Workflows {
+		if seen[current.WorkflowID] {
+			continue
+		}
+		wd := WorkflowDiff{Name: current.WorkflowID, Change: ChangeRemove, Status: current.Status}
+		for _, prev := range current.Tasks {
+			wd.Tasks = append(wd.Tasks, removedTask(prev))
+		}
+		diff.Workflows = append(diff.Workflows, wd)
+	}
+
+	return diff, nil
+}
+
+// TasksToRun returns the qualified IDs of the tasks an apply of the workflows
+// runs: every task that is new, changed or didn't complete, together with
+// every task downstream of one of those, across workflows, so that a task is
+// never resumed past while something it depends on runs again
+func (s *StateFile) TasksToRun(workflows []fsparse.Workflow) map[string]bool {
+	toRun := make(map[string]bool)
+	dependents := make(map[string][]string)
+	var queue []string
+
+	for _, w := range workflows {
+		current := s.FindWorkflow(w.Name)
+		for _, task := range w.Tasks {
+			id := fsparse.QualifiedTaskID(w.Name, task.ID)
+			for _, dep := range w.TaskDependencies(task.ID) {
+				if depWorkflow, _ := fsparse.SplitTaskID(dep); depWorkflow == "" {
+					dep = fsparse.QualifiedTaskID(w.Name, dep)
+				}
+				dependents[dep] = append(dependents[dep], id)
+			}
+
+			var prev *TaskState
+			if current != nil {
+				prev = current.FindTask(task.ID)
+			}
+			if prev == nil || prev.Status != "completed" || prev.Hash != w.TaskHash(task.ID) {
+				toRun[id] = true
+				queue = append(queue, id)
+			}
+		}
+	}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range dependents[id] {
+			if !toRun[next] {
+				toRun[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return toRun
+}
+
+// diffTask compares a parsed task with its state, which is nil for a new task.
+// run reports whether the apply runs the task.
+func diffTask(w fsparse.Workflow, task fsparse.Task, prev *TaskState, run bool) TaskDiff {
+	deps := w.TaskDependencies(task.ID)
+	td := TaskDiff{
+		ID:           task.ID,
+		Change:       ChangeNone,
+		Command:      task.Command,
+		Dependencies: deps,
+		Priority:     task.Priority,
+		Retries:      task.Retries,
+		Timeout:      task.Timeout,
+		Status:       "pending",
+	}
+
+	if prev == nil {
+		td.Change = ChangeAdd
+		return td
+	}
+	td.Status = prev.Status
+
+	// An unchanged definition hash means nothing that affects the run changed,
+	// even if the state predates some of the fields compared below
+	if prev.Hash == "" || prev.Hash != w.TaskHash(task.ID) {
+		compare := func(field, old, new string) {
+			if old != new {
+				td.Fields = append(td.Fields, FieldChange{Field: field, Old: old, New: new})
+			}
+		}
+		compare("command", quote(prev.Command), quote(task.Command))
+		compare("dependencies", "["+FormatDependencies(prev.Dependencies)+"]", "["+FormatDependencies(deps)+"]")
+		compare("priority", quote(prev.Priority), quote(task.Priority))
+		compare("retries", fmt.Sprint(prev.Retries), fmt.Sprint(task.Retries))
+		compare("timeout", quote(prev.Timeout), quote(task.Timeout))
+		compare("env", "{"+formatEnv(prev.Env)+"}", "{"+formatEnv(task.Env)+"}")
+		compare("workdir", quote(prev.Workdir), quote(task.Workdir))
+	}
+
+	if len(td.Fields) > 0 {
+		td.Change = ChangeUpdate
+	} else if run {
+		td.Rerun = true
+	}
+	return td
+}
+
+func removedTask(prev TaskState) TaskDiff {
+	deps := prev.Dependencies
+	if deps == nil {
+		// Older states record no dependencies as null
+		deps = []string{}
+	}
+	return TaskDiff{
+		ID:           prev.ID,
+		Change:       ChangeRemove,
+		Command:      prev.Command,
+		Dependencies: deps,
+		Priority:     prev.Priority,
+		Retries:      prev.Retries,
+		Timeout:      prev.Timeout,
+		Status:       prev.Status,
+	}
+}
+
+// FormatDependencies renders a dependency list as comma-separated quoted IDs
+func FormatDependencies(deps []string) string {
+	quoted := make([]string, len(deps))
+	for i, dep := range deps {
+		quoted[i] = quote(dep)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func formatEnv(env map[string]string) string {
+	pairs := make([]string, 0, len(env))
+	for k, v := range env {
+		pairs = append(pairs, fmt.Sprintf("%q: %q", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+func quote(s string) string {
+	return fmt.Sprintf("%q", s)
+}
@@ -0,0 +1,181 @@
+package state
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockSuffix is appended to the state file's path to name its lock file
+const LockSuffix = ".lock"
+
+// lockPollInterval is how often a held lock is retried while waiting for it
+const lockPollInterval = 100 * time.Millisecond
+
+// LockInfo describes who holds a state lock. It is the content of the lock
+// file.
+type LockInfo struct {
+	ID        string    `json:"id"`
+	Operation string    `json:"operation"`
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LockedError is returned when the state is locked by someone else
+type LockedError struct {
+	Path string
+	// Info is the current holder, nil when the lock file couldn't be read
+	Info *LockInfo
+}
+
+func (e *LockedError) Error() string {
+	if e.Info == nil {
+		return fmt.Sprintf("the state is locked (%s)", e.Path)
+	}
+	return fmt.Sprintf("the state is locked by %s (pid %d on %s, since %s, lock ID %s); wait for it to finish, or run `tgfs force-unlock %s` if that process is gone",
+		e.Info.Operation, e.Info.PID, e.Info.Host, e.Info.CreatedAt.Format(time.RFC3339), e.Info.ID, e.Info.ID)
+}
+
+// errLockHeld is returned by tryLock when another process holds the lock
+var errLockHeld = errors.New("lock held")
+
+// Lock is a held state lock
+type Lock struct {
+	Info LockInfo
+	path string
+	file *os.File
+}
+
+// LockPath returns the lock file of the given state file
+func LockPath(statePath string) string {
+	return statePath + LockSuffix
+}
+
+// AcquireLock takes the advisory lock on the given state file for an
+// operation such as "apply". When another process holds it, AcquireLock waits
+// up to timeout for it to be released and then fails with a *LockedError.
+func AcquireLock(ctx context.Context, statePath, operation string, timeout time.Duration) (*Lock, error) {
+	info, err := newLockInfo(operation)
+	if err != nil {
+		return nil, err
+	}
+
+	path := LockPath(statePath)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		lock, err := tryLock(path, info)
+		if !errors.Is(err, errLockHeld) {
+			return lock, err
+		}
+
+		if !time.Now().Before(deadline) {
+			holder, _ := ReadLockInfo(statePath)
+			return nil, &LockedError{Path: path, Info: holder}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// ReadLockInfo returns the holder of the lock on the given state file, or nil
+// when it isn't locked
+func ReadLockInfo(statePath string) (*LockInfo, error) {
+	data, err := os.ReadFile(LockPath(statePath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock file: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var info LockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse lock file: %w", err)
+	}
+	return &info, nil
+}
+
+// ForceUnlock removes the lock on the given state file if its ID matches.
+// The holder, if it is still running, is not stopped and may still write the
+// state.
+func ForceUnlock(statePath, id string) (*LockInfo, error) {
+	info, err := ReadLockInfo(statePath)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, fmt.Errorf("the state is not locked")
+	}
+	if info.ID != id {
+		return nil, fmt.Errorf("lock ID %s doesn't match the current lock %s", id, info.ID)
+	}
+	if err := os.Remove(LockPath(statePath)); err != nil {
+		return nil, fmt.Errorf("failed to remove lock file: %w", err)
+	}
+	return info, nil
+}
+
+func newLockInfo(operation string) (LockInfo, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return LockInfo{}, fmt.Errorf("failed to generate lock ID: %w", err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return LockInfo{
+		ID:        hex.EncodeToString(id),
+		Operation: operation,
+		PID:       os.Getpid(),
+		Host:      host,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// writeInfo records the lock holder in the lock file
+func (l *Lock) writeInfo() error {
+	data, err := json.MarshalIndent(l.Info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal lock info: %w", err)
+	}
+	if err := l.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	if _, err := l.file.WriteAt(data, 0); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return l.file.Sync()
+}
+
+// ownsPath reports whether the lock file at the lock's path is still the one
+// this lock holds, rather than one created after a force-unlock
+func (l *Lock) ownsPath() bool {
+	held, err := l.file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(l.path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(held, current)
+}
@@ -0,0 +1,52 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// tryLock takes an flock on the lock file without waiting. The kernel drops
+// the lock when its holder exits, so a crashed apply never leaves the state
+// locked.
+func tryLock(path string, info LockInfo) (*Lock, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %w", err)
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			f.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, errLockHeld
+			}
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+
+		lock := &Lock{Info: info, path: path, file: f}
+		// The previous holder removes the file when it releases the lock, so
+		// the file we locked may no longer be the lock file; try again then
+		if !lock.ownsPath() {
+			f.Close()
+			continue
+		}
+
+		if err := lock.writeInfo(); err != nil {
+			lock.Release()
+			return nil, err
+		}
+		return lock, nil
+	}
+}
+
+// Release removes the lock file and unlocks it
+func (l *Lock) Release() error {
+	if l.ownsPath() {
+		os.Remove(l.path)
+	}
+	// Closing the file drops the flock
+	return l.file.Close()
+}
@@ -0,0 +1,40 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package state
+
+import (
+	"fmt"
+	"os"
+)
+
+// tryLock creates the lock file exclusively without waiting. Without flock a
+// lock left behind by a crashed apply stays until `tgfs force-unlock` removes
+// it.
+func tryLock(path string, info LockInfo) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if os.IsExist(err) {
+		return nil, errLockHeld
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock file: %w", err)
+	}
+
+	lock := &Lock{Info: info, path: path, file: f}
+	if err := lock.writeInfo(); err != nil {
+		lock.Release()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Release removes the lock file
+func (l *Lock) Release() error {
+	owned := l.ownsPath()
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	if owned {
+		return os.Remove(l.path)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+	ctx := context.Background()
+	statePath := filepath.Join(t.TempDir(), StateFileName)
+
+	lock, err := AcquireLock(ctx, statePath, "apply", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Info.PID != os.Getpid() || lock.Info.Host == "" || lock.Info.ID == "" {
+		t.Errorf("expected the lock to record its owner, got %+v", lock.Info)
+	}
+
+	info, err := ReadLockInfo(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info == nil || info.ID != lock.Info.ID {
+		t.Fatalf("expected the lock file to hold the lock info, got %+v", info)
+	}
+
+	_, err = AcquireLock(ctx, statePath, "apply", 0)
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("expected a LockedError, got %v", err)
+	}
+	if locked.Info == nil || locked.Info.ID != lock.Info.ID {
+		t.Errorf("expected the error to name the holder, got %+v", locked.Info)
+	}
+
+	// A waiting apply gets the lock once it is released
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		lock.Release()
+	}()
+	second, err := AcquireLock(ctx, statePath, "apply", 5*time.Second)
+	if err != nil {
+		t.Fatalf("expected to acquire the lock after it was released, got %v", err)
+	}
+	if err := second.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(LockPath(statePath)); !os.IsNotExist(err) {
+		t.Error("expected the lock file to be removed on release")
+	}
+}
+
+func TestForceUnlock(t *testing.T) {
+	ctx := context.Background()
+	statePath := filepath.Join(t.TempDir(), StateFileName)
+
+	if _, err := ForceUnlock(statePath, "abc"); err == nil {
+		t.Error("expected unlocking an unlocked state to fail")
+	}
+
+	stuck, err := AcquireLock(ctx, statePath, "apply", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ForceUnlock(statePath, "wrong"); err == nil {
+		t.Error("expected a mismatched lock ID to be refused")
+	}
+	info, err := ForceUnlock(statePath, stuck.Info.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != stuck.Info.ID {
+		t.Errorf("expected the released lock's info, got %+v", info)
+	}
+
+	lock, err := AcquireLock(ctx, statePath, "apply", 0)
+	if err != nil {
+		t.Fatalf("expected the state to be lockable after force-unlock, got %v", err)
+	}
+	defer lock.Release()
+
+	// The stuck holder releasing late must not remove the new lock
+	stuck.Release()
+	info, err = ReadLockInfo(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info == nil || info.ID != lock.Info.ID {
+		t.Errorf("expected the new lock to survive the old holder's release, got %+v", info)
+	}
+}
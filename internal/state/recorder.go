@@ -0,0 +1,133 @@
+package state
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// recordInterval is the shortest time between two background saves of a
+// Recorder; changes made in between are saved together
+const recordInterval = 200 * time.Millisecond
+
+// Recorder persists a state file while an apply changes it, so that an
+// interrupted or crashed apply leaves an accurate state behind. Whoever
+// changes the state holds the recorder's lock while doing so; the recorder is
+// a sync.Locker so that it can be shared with the orchestrators of an apply.
+//
+// Changes are saved by a background writer, which coalesces changes that
+// arrive while it is writing, so reporting a change never waits on the disk.
+// The previous state file is backed up once, by the first save, so the
+// backup is the state from before the apply.
+type Recorder struct {
+	mu    sync.Mutex
+	state *StateFile
+	path  string
+
+	// saveMu keeps saves in the order their snapshots were taken
+	saveMu   sync.Mutex
+	backedUp bool
+
+	changed chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	started bool
+}
+
+// NewRecorder creates a recorder that saves st to the state file at path
+func NewRecorder(st *StateFile, path string) *Recorder {
+	return &Recorder{
+		state:   st,
+		path:    path,
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Lock locks the state against concurrent changes and saves
+func (r *Recorder) Lock() { r.mu.Lock() }
+
+// Unlock unlocks the state
+func (r *Recorder) Unlock() { r.mu.Unlock() }
+
+// Update changes the state while holding the lock
+func (r *Recorder) Update(fn func(*StateFile)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(r.state)
+}
+
+// Start starts the background writer. Close must be called once the state
+// stops changing.
+func (r *Recorder) Start() {
+	r.started = true
+	go r.run()
+}
+
+// Changed schedules a save of the state by the background writer
+func (r *Recorder) Changed() {
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the background writer and saves the final state
+func (r *Recorder) Close(ctx context.Context) error {
+	if r.started {
+		close(r.done)
+		<-r.stopped
+		r.started = false
+	}
+	return r.Save(ctx)
+}
+
+func (r *Recorder) run() {
+	defer close(r.stopped)
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.changed:
+		}
+
+		// A failed save is retried with the next change and reported by Close
+		_ = r.save()
+
+		select {
+		case <-r.done:
+			return
+		case <-time.After(recordInterval):
+		}
+	}
+}
+
+// Save writes a consistent snapshot of the state to the state file straight
+// away
+func (r *Recorder) Save(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return r.save()
+	}
+}
+
+func (r *Recorder) save() error {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	r.mu.Lock()
+	data, err := r.state.marshal()
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := writeStateFile(r.path, data, !r.backedUp); err != nil {
+		return err
+	}
+	r.backedUp = true
+	return nil
+}
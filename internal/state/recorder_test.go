@@ -0,0 +1,123 @@
+package state
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), DefaultFileName)
+
+	st := &StateFile{
+		RunID: "run-1",
+		Workflows: []WorkflowState{{
+			WorkflowID: "ci",
+			Status:     "running",
+			Tasks:      []TaskState{{ID: "build", Status: "pending"}, {ID: "test", Status: "pending"}},
+		}},
+	}
+	recorder := NewRecorder(st, path)
+	if err := recorder.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent changes and saves always leave a complete state behind
+	var wg sync.WaitGroup
+	for i := range st.Workflows[0].Tasks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recorder.Update(func(s *StateFile) {
+				s.Workflows[0].Tasks[i].Status = "completed"
+			})
+			if err := recorder.Save(ctx); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	saved, err := LoadStateFrom(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != SchemaVersion || saved.RunID != "run-1" {
+		t.Errorf("unexpected saved state: %+v", saved)
+	}
+	for _, task := range saved.Workflows[0].Tasks {
+		if task.Status != "completed" {
+			t.Errorf("expected task %s to be saved as completed, got %s", task.ID, task.Status)
+		}
+	}
+}
+
+func TestRecorderSavesInBackground(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), DefaultFileName)
+
+	previous := &StateFile{RunID: "run-0"}
+	if err := previous.SaveTo(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+
+	st := &StateFile{
+		RunID: "run-1",
+		Workflows: []WorkflowState{{
+			WorkflowID: "ci",
+			Status:     "running",
+			Tasks:      []TaskState{{ID: "build", Status: "pending"}},
+		}},
+	}
+	recorder := NewRecorder(st, path)
+	if err := recorder.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Start()
+
+	// A change is saved without waiting for Close
+	recorder.Update(func(s *StateFile) {
+		s.Workflows[0].Tasks[0].Status = "running"
+	})
+	recorder.Changed()
+	deadline := time.Now().Add(5 * time.Second)
+	for savedTaskStatus(t, path) != "running" {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the change to be saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Close saves the last change even if the writer did not get to it
+	recorder.Update(func(s *StateFile) {
+		s.Workflows[0].Tasks[0].Status = "completed"
+	})
+	recorder.Changed()
+	if err := recorder.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if status := savedTaskStatus(t, path); status != "completed" {
+		t.Errorf("expected the final state to be saved, got task status %q", status)
+	}
+
+	// The backup is the state from before the recorder, not an earlier save
+	backup, err := LoadStateFrom(ctx, path+BackupSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.RunID != "run-0" {
+		t.Errorf("expected the backup to hold the previous run, got %q", backup.RunID)
+	}
+}
+
+func savedTaskStatus(t *testing.T, path string) string {
+	t.Helper()
+	saved, err := LoadStateFrom(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return saved.Workflows[0].Tasks[0].Status
+}
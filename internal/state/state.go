@@ -3,107 +3,226 @@ package state
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/zackiles/task-graph-fs/internal/fsparse"
+	"github.com/zackiles/task-graph-fs/internal/fsutil"
 )
 
+// SchemaVersion is the version of the state file format written by this
+// version of tgfs. State files written before the format was versioned have
+// no version and are read as version 0.
+const SchemaVersion = 1
+
+// ErrUnsupportedVersion is returned when loading a state file written by a
+// newer version of tgfs
+var ErrUnsupportedVersion = errors.New("unsupported state file version")
+
 type StateFile struct {
+	Version int `json:"version"`
+	// RunID identifies the apply that last wrote this state; task logs live
+	// in that run's directory
+	RunID     string          `json:"run_id,omitempty"`
 	Workflows []WorkflowState `json:"workflows"`
 }
 
 type WorkflowState struct {
 	WorkflowID string      `json:"workflow_id"`
 	Status     string      `json:"status"`
+	StartedAt  *time.Time  `json:"started_at,omitempty"`
+	EndedAt    *time.Time  `json:"ended_at,omitempty"`
+	Duration   string      `json:"duration,omitempty"`
+	Error      string      `json:"error,omitempty"`
 	Tasks      []TaskState `json:"tasks"`
 }
 
 type TaskState struct {
-	ID           string   `json:"id"`
-	Command      string   `json:"command"`
-	Dependencies []string `json:"dependencies"`
-	Priority     string   `json:"priority"`
-	Retries      int      `json:"retries"`
-	Status       string   `json:"status"`
-	Output       string   `json:"output,omitempty"`
+	ID           string            `json:"id"`
+	Command      string            `json:"command"`
+	Dependencies []string          `json:"dependencies"`
+	Priority     string            `json:"priority"`
+	Retries      int               `json:"retries"`
+	Timeout      string            `json:"timeout,omitempty"`
+	Env          map[string]string `json:"env,omitempty"`
+	Workdir      string            `json:"workdir,omitempty"`
+	Status       string            `json:"status"`
+	Hash         string            `json:"hash,omitempty"`
+	RunID        string            `json:"run_id,omitempty"`
+	Output       string            `json:"output,omitempty"`
+	LogPath      string            `json:"log_path,omitempty"`
+	// StartedAt, EndedAt, Duration, AttemptCount, ExitCode and Error describe
+	// the task's last run as a whole; Attempts holds every attempt of it
+	StartedAt    *time.Time     `json:"started_at,omitempty"`
+	EndedAt      *time.Time     `json:"ended_at,omitempty"`
+	Duration     string         `json:"duration,omitempty"`
+	AttemptCount int            `json:"attempt_count,omitempty"`
+	ExitCode     *int           `json:"exit_code,omitempty"`
+	Error        string         `json:"error,omitempty"`
+	Attempts     []AttemptState `json:"attempts,omitempty"`
+}
+
+// FormatDuration renders a run duration for the state file and apply output
+func FormatDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
 }
 
-// LoadState loads the state from the state file
+// AttemptState records a single execution attempt of a task
+type AttemptState struct {
+	Number    int       `json:"number"`
+	ExitCode  int       `json:"exit_code"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// FindWorkflow returns the state of the given workflow, or nil if it isn't tracked
+func (s *StateFile) FindWorkflow(workflowID string) *WorkflowState {
+	for i := range s.Workflows {
+		if s.Workflows[i].WorkflowID == workflowID {
+			return &s.Workflows[i]
+		}
+	}
+	return nil
+}
+
+// FindTask returns the state of the given task, or nil if it isn't tracked
+func (w *WorkflowState) FindTask(taskID string) *TaskState {
+	for i := range w.Tasks {
+		if w.Tasks[i].ID == taskID {
+			return &w.Tasks[i]
+		}
+	}
+	return nil
+}
+
+// DefaultFileName is the name of the state file in the workspace root
+const DefaultFileName = "tgfs-state.json"
+
+// LoadState loads the state from tgfs-state.json in the current directory
 func LoadState(ctx context.Context) (*StateFile, error) {
+	return LoadStateFrom(ctx, DefaultFileName)
+}
+
+// BackupSuffix is appended to the state file's path to name its backup, a
+// copy of the last valid state before the most recent apply or SaveTo
+const BackupSuffix = ".backup"
+
+// Warnings receives a warning when a corrupt state file is recovered from its
+// backup
+var Warnings io.Writer = os.Stderr
+
+// LoadStateFrom loads the state from the given state file. A missing file is
+// an empty state. A state file that can't be parsed, such as one truncated by
+// a crash, is recovered from its backup with a warning.
+func LoadStateFrom(ctx context.Context, path string) (*StateFile, error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
-		data, err := os.ReadFile("tgfs-state.json")
-		if err != nil {
-			if os.IsNotExist(err) {
-				return &StateFile{}, nil
-			}
-			return nil, fmt.Errorf("failed to read state file: %w", err)
+		state, err := readStateFile(path)
+		if err == nil || errors.Is(err, ErrUnsupportedVersion) {
+			return state, err
 		}
 
-		var state StateFile
-		if err := json.Unmarshal(data, &state); err != nil {
-			return nil, fmt.Errorf("failed to parse state file: %w", err)
+		backupPath := path + BackupSuffix
+		if _, statErr := os.Stat(backupPath); statErr != nil {
+			return nil, err
+		}
+		backup, backupErr := readStateFile(backupPath)
+		if backupErr != nil {
+			return nil, err
 		}
+		fmt.Fprintf(Warnings, "Warning: %v; recovered the state from %s\n", err, backupPath)
+		return backup, nil
+	}
+}
 
-		return &state, nil
+// readStateFile reads and parses a state file. A missing file is an empty
+// state.
+func readStateFile(path string) (*StateFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &StateFile{}, nil
+		}
+		return nil, fmt.Errorf("failed to read state file: %w", err)
 	}
+
+	var state StateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	if state.Version > SchemaVersion {
+		return nil, fmt.Errorf("%w: %s has schema version %d, this version of tgfs supports up to %d", ErrUnsupportedVersion, path, state.Version, SchemaVersion)
+	}
+	return &state, nil
 }
 
-// Save writes the state to the state file
+// Save writes the state to tgfs-state.json in the current directory
 func (s *StateFile) Save(ctx context.Context) error {
+	return s.SaveTo(ctx, DefaultFileName)
+}
+
+// SaveTo atomically replaces the given state file, creating its directory if
+// needed. The previous state is kept as the backup first, unless it is
+// corrupt, in which case the existing backup is kept.
+func (s *StateFile) SaveTo(ctx context.Context, path string) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
-		data, err := json.MarshalIndent(s, "", "  ")
+		data, err := s.marshal()
 		if err != nil {
-			return fmt.Errorf("failed to marshal state: %w", err)
-		}
-
-		if err := os.WriteFile("tgfs-state.json", data, 0o644); err != nil {
-			return fmt.Errorf("failed to write state file: %w", err)
+			return err
 		}
-
-		return nil
+		return writeStateFile(path, data, true)
 	}
 }
 
-// ComputeDiff compares the current state with the new workflows and returns
-// lists of workflows that need to be added, updated, or removed
-func (s *StateFile) ComputeDiff(ctx context.Context, workflows []fsparse.Workflow) (added, updated, removed []string, err error) {
-	select {
-	case <-ctx.Done():
-		return nil, nil, nil, ctx.Err()
-	default:
-		// Create maps for quick lookups
-		currentWorkflows := make(map[string]bool)
-		newWorkflows := make(map[string]bool)
+// marshal stamps the state with the current schema version and encodes it
+func (s *StateFile) marshal() ([]byte, error) {
+	s.Version = SchemaVersion
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal state: %w", err)
+	}
+	return data, nil
+}
 
-		// Track current workflows
-		for _, w := range s.Workflows {
-			currentWorkflows[w.WorkflowID] = true
-		}
+// writeStateFile atomically replaces the state file with data, first backing
+// up the current one when backup is set
+func writeStateFile(path string, data []byte, backup bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
 
-		// Track new workflows and find additions/updates
-		for _, w := range workflows {
-			newWorkflows[w.Name] = true
-			if !currentWorkflows[w.Name] {
-				added = append(added, w.Name)
-			} else {
-				updated = append(updated, w.Name)
+	if backup {
+		if previous, err := os.ReadFile(path); err == nil && json.Valid(previous) {
+			if err := fsutil.WriteFileAtomic(path+BackupSuffix, previous, 0o644); err != nil {
+				return fmt.Errorf("failed to back up state file: %w", err)
 			}
 		}
+	}
 
-		// Find removals
-		for _, w := range s.Workflows {
-			if !newWorkflows[w.WorkflowID] {
-				removed = append(removed, w.WorkflowID)
-			}
-		}
+	if err := fsutil.WriteFileAtomic(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
 
-		return added, updated, removed, nil
+// ComputeDiff compares the current state with the new workflows and returns
+// lists of workflows that need to be added, updated, or removed. A workflow
+// is only updated if one of its tasks is added, changed, removed or needs to
+// run again; see Diff for the task-level changes.
+func (s *StateFile) ComputeDiff(ctx context.Context, workflows []fsparse.Workflow) (added, updated, removed []string, err error) {
+	diff, err := s.Diff(ctx, workflows)
+	if err != nil {
+		return nil, nil, nil, err
 	}
+	return diff.WorkflowNames(ChangeAdd), diff.WorkflowNames(ChangeUpdate), diff.WorkflowNames(ChangeRemove), nil
 }
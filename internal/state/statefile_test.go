@@ -1,7 +1,13 @@
 package state
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/zackiles/task-graph-fs/internal/fsparse"
@@ -32,13 +38,13 @@ func TestStateFileOperations(t *testing.T) {
 	}
 
 	// Save state
-	if err := testState.Save(); err != nil {
+	if err := testState.Save(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 	defer os.Remove(StateFileName)
 
 	// Load state
-	loaded, err := LoadState()
+	loaded, err := LoadState(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -55,6 +61,95 @@ func TestStateFileOperations(t *testing.T) {
 	}
 }
 
+func TestStateFileRecovery(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state", StateFileName)
+
+	var warnings bytes.Buffer
+	Warnings = &warnings
+	defer func() { Warnings = os.Stderr }()
+
+	first := &StateFile{RunID: "run-1"}
+	if err := first.SaveTo(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + BackupSuffix); !os.IsNotExist(err) {
+		t.Error("expected no backup before there is a previous state")
+	}
+
+	second := &StateFile{RunID: "run-2"}
+	if err := second.SaveTo(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash that left the state file truncated
+	if err := os.WriteFile(path, []byte(`{"run_id": "run-3", "workf`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadStateFrom(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.RunID != "run-1" {
+		t.Errorf("expected the state to be recovered from the backup, got run %q", loaded.RunID)
+	}
+	if !strings.Contains(warnings.String(), "recovered the state from") {
+		t.Errorf("expected a recovery warning, got %q", warnings.String())
+	}
+
+	// Saving over the corrupt file keeps the good backup
+	third := &StateFile{RunID: "run-3"}
+	if err := third.SaveTo(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := os.ReadFile(path + BackupSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(backup), "run-1") {
+		t.Errorf("expected the backup to keep the last valid state, got %s", backup)
+	}
+
+	// Without a usable backup a corrupt state file is an error
+	if err := os.Remove(path + BackupSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadStateFrom(ctx, path); err == nil {
+		t.Error("expected a corrupt state file without a backup to fail to load")
+	}
+}
+
+func TestStateFileVersion(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), StateFileName)
+
+	if err := (&StateFile{}).SaveTo(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadStateFrom(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Version != SchemaVersion {
+		t.Errorf("expected saved state to have version %d, got %d", SchemaVersion, loaded.Version)
+	}
+
+	// A newer state isn't replaced by the backup, it is refused
+	if err := (&StateFile{}).SaveTo(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"version": 99, "workflows": []}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadStateFrom(ctx, path); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
 func TestComputeDiff(t *testing.T) {
 	currentState := &StateFile{
 		Workflows: []WorkflowState{
@@ -92,7 +187,10 @@ func TestComputeDiff(t *testing.T) {
 		},
 	}
 
-	added, updated, removed := currentState.ComputeDiff(newWorkflows)
+	added, updated, removed, err := currentState.ComputeDiff(context.Background(), newWorkflows)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if len(added) != 1 || added[0] != "new" {
 		t.Errorf("expected one addition 'new', got %v", added)
@@ -106,3 +204,147 @@ func TestComputeDiff(t *testing.T) {
 		t.Errorf("expected no removals, got %v", removed)
 	}
 }
+
+func TestDiffTaskLevel(t *testing.T) {
+	workflow := fsparse.Workflow{
+		Name: "etl",
+		Tasks: []fsparse.Task{
+			{ID: "extract", Command: "echo extract", Priority: "high", Timeout: "1m"},
+			{ID: "load", Command: "echo load v2", Priority: "low", Timeout: "1m"},
+			{ID: "report", Command: "echo report", Priority: "low", Timeout: "1m"},
+			{ID: "notify", Command: "echo notify", Priority: "low", Timeout: "1m"},
+		},
+		Dependencies: map[string][]string{"load": {"extract"}},
+	}
+
+	currentState := &StateFile{
+		Workflows: []WorkflowState{
+			{
+				WorkflowID: "etl",
+				Status:     "failed",
+				Tasks: []TaskState{
+					{ID: "extract", Command: "echo extract", Priority: "high", Timeout: "1m", Status: "completed", Hash: workflow.TaskHash("extract")},
+					{ID: "load", Command: "echo load", Dependencies: []string{"extract"}, Priority: "low", Timeout: "1m", Status: "completed"},
+					{ID: "report", Command: "echo report", Priority: "low", Timeout: "1m", Status: "failed", Hash: workflow.TaskHash("report")},
+					{ID: "cleanup", Command: "echo cleanup", Status: "completed"},
+				},
+			},
+			{WorkflowID: "legacy", Status: "completed"},
+		},
+	}
+
+	diff, err := currentState.Diff(context.Background(), []fsparse.Workflow{workflow})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diff.Workflows) != 2 || diff.Workflows[0].Change != ChangeUpdate || diff.Workflows[1].Change != ChangeRemove {
+		t.Fatalf("expected etl to be updated and legacy removed, got %+v", diff.Workflows)
+	}
+
+	tasks := make(map[string]TaskDiff)
+	for _, task := range diff.Workflows[0].Tasks {
+		tasks[task.ID] = task
+	}
+
+	if tasks["extract"].Change != ChangeNone || tasks["extract"].Rerun {
+		t.Errorf("expected completed, unchanged extract to be a no-op, got %+v", tasks["extract"])
+	}
+
+	load := tasks["load"]
+	if load.Change != ChangeUpdate || len(load.Fields) != 1 || load.Fields[0] != (FieldChange{Field: "command", Old: `"echo load"`, New: `"echo load v2"`}) {
+		t.Errorf("expected only the load command to change, got %+v", load)
+	}
+
+	if tasks["report"].Change != ChangeNone || !tasks["report"].Rerun {
+		t.Errorf("expected failed, unchanged report to run again, got %+v", tasks["report"])
+	}
+	if tasks["notify"].Change != ChangeAdd {
+		t.Errorf("expected notify to be added, got %+v", tasks["notify"])
+	}
+	if tasks["cleanup"].Change != ChangeRemove {
+		t.Errorf("expected cleanup to be removed, got %+v", tasks["cleanup"])
+	}
+
+	// Tasks without dependencies list none rather than null
+	encoded, err := json.Marshal(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encoded, []byte(`"dependencies":null`)) {
+		t.Errorf("expected every task to have a dependencies list, got %s", encoded)
+	}
+
+	// Once everything has completed unchanged there is nothing to apply
+	done := &StateFile{Workflows: []WorkflowState{{WorkflowID: "etl", Status: "completed"}}}
+	for _, task := range workflow.Tasks {
+		done.Workflows[0].Tasks = append(done.Workflows[0].Tasks, TaskState{ID: task.ID, Status: "completed", Hash: workflow.TaskHash(task.ID)})
+	}
+	diff, err = done.Diff(context.Background(), []fsparse.Workflow{workflow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.HasChanges() {
+		t.Errorf("expected no changes, got %+v", diff.Workflows)
+	}
+}
+
+func TestTasksToRunIncludesDownstream(t *testing.T) {
+	// a -> b -> c, with a report workflow depending on c from another
+	// workflow. a changed, b completed before and c failed.
+	chain := fsparse.Workflow{
+		Name: "chain",
+		Tasks: []fsparse.Task{
+			{ID: "a", Command: "exit 1"},
+			{ID: "b", Command: "echo b"},
+			{ID: "c", Command: "echo c"},
+			{ID: "lint", Command: "echo lint"},
+		},
+		Dependencies: map[string][]string{"b": {"a"}, "c": {"b"}},
+	}
+	report := fsparse.Workflow{
+		Name:         "report",
+		Tasks:        []fsparse.Task{{ID: "publish", Command: "echo publish"}},
+		Dependencies: map[string][]string{"publish": {"chain/c"}},
+	}
+
+	completed := func(w fsparse.Workflow, id string) TaskState {
+		return TaskState{ID: id, Status: "completed", Hash: w.TaskHash(id)}
+	}
+	currentState := &StateFile{
+		Workflows: []WorkflowState{
+			{
+				WorkflowID: "chain",
+				Status:     "failed",
+				Tasks: []TaskState{
+					{ID: "a", Status: "completed", Hash: "outdated"},
+					completed(chain, "b"),
+					{ID: "c", Status: "failed", Hash: chain.TaskHash("c")},
+					completed(chain, "lint"),
+				},
+			},
+			{WorkflowID: "report", Status: "completed", Tasks: []TaskState{completed(report, "publish")}},
+		},
+	}
+
+	toRun := currentState.TasksToRun([]fsparse.Workflow{chain, report})
+	for _, id := range []string{"chain/a", "chain/b", "chain/c", "report/publish"} {
+		if !toRun[id] {
+			t.Errorf("expected %s to run", id)
+		}
+	}
+	if toRun["chain/lint"] {
+		t.Error("expected lint, which depends on nothing that runs, to be resumed past")
+	}
+
+	diff, err := currentState.Diff(context.Background(), []fsparse.Workflow{chain, report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := diff.Workflows[0].Tasks[1]; b.Change != ChangeNone || !b.Rerun {
+		t.Errorf("expected unchanged b to run again after a, got %+v", b)
+	}
+	if diff.Workflows[1].Change != ChangeUpdate {
+		t.Errorf("expected report to be updated since publish runs again, got %+v", diff.Workflows[1])
+	}
+}
@@ -0,0 +1,59 @@
+package workspace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"path/filepath"
+	"time"
+)
+
+// MetadataDir is the hidden directory under the workspace root where tgfs
+// keeps run logs and other generated files
+const MetadataDir = ".tgfs"
+
+// RunsDir returns the directory that holds every run of the workspace
+func RunsDir(root string) string {
+	return filepath.Join(root, MetadataDir, "runs")
+}
+
+// RunDir returns the directory for a single run
+func RunDir(root, runID string) string {
+	return filepath.Join(RunsDir(root), runID)
+}
+
+// CacheDir returns the directory that holds cached task extractions
+func CacheDir(root string) string {
+	return filepath.Join(root, MetadataDir, "cache")
+}
+
+// TaskLogPath returns the path of a task's log file relative to its run
+// directory. Nested workflow names keep their directory structure.
+func TaskLogPath(workflowName, taskID string) string {
+	return filepath.Join(filepath.FromSlash(workflowName), taskID+".log")
+}
+
+// runIDLayout is the timestamp every run ID starts with
+const runIDLayout = "20060102T150405Z"
+
+// NewRunID returns a sortable, unique identifier for a new run
+func NewRunID() string {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		// The timestamp alone is still a usable identifier
+		return time.Now().UTC().Format(runIDLayout)
+	}
+	return time.Now().UTC().Format(runIDLayout) + "-" + hex.EncodeToString(suffix)
+}
+
+// RunStartTime returns when a run started, to the second, as recorded in its
+// ID. It reports false for IDs that don't start with a timestamp.
+func RunStartTime(runID string) (time.Time, bool) {
+	if len(runID) < len(runIDLayout) {
+		return time.Time{}, false
+	}
+	started, err := time.Parse(runIDLayout, runID[:len(runIDLayout)])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return started, true
+}